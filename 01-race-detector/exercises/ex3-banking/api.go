@@ -0,0 +1,328 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// apiErrors maps domain errors to an HTTP status and a stable error code.
+// The first match wins.
+var apiErrors = []struct {
+	err    error
+	status int
+	code   string
+}{
+	{ErrUnknownAccount, http.StatusNotFound, "unknown_account"},
+	{ErrUnknownHold, http.StatusNotFound, "unknown_hold"},
+	{ErrInsufficientFunds, http.StatusUnprocessableEntity, "insufficient_funds"},
+	{ErrAccountFrozen, http.StatusUnprocessableEntity, "account_frozen"},
+	{ErrAccountClosed, http.StatusUnprocessableEntity, "account_closed"},
+	{ErrCurrencyMismatch, http.StatusUnprocessableEntity, "currency_mismatch"},
+	{ErrNoRate, http.StatusUnprocessableEntity, "no_fx_rate"},
+	{ErrUnbalanced, http.StatusUnprocessableEntity, "unbalanced"},
+	{ErrEmptyTransfer, http.StatusUnprocessableEntity, "empty_transfer"},
+	{ErrWrongPayee, http.StatusUnprocessableEntity, "wrong_payee"},
+	{ErrIdempotencyConflict, http.StatusConflict, "idempotency_conflict"},
+	{ErrTransferRejected, http.StatusForbidden, "transfer_rejected"},
+	{ErrUnknownCurrency, http.StatusBadRequest, "unknown_currency"},
+	{ErrInvalidAmount, http.StatusBadRequest, "invalid_amount"},
+}
+
+// apiError is the body of every failed request
+type apiError struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// badRequest marks errors caused by a malformed request
+type badRequest struct{ error }
+
+// accountJSON describes one account
+type accountJSON struct {
+	ID        int           `json:"id"`
+	Currency  Currency      `json:"currency"`
+	Balance   int           `json:"balance"`
+	Available int           `json:"available"`
+	Status    AccountStatus `json:"status"`
+}
+
+// transactionJSON is one journal entry as seen from one account
+type transactionJSON struct {
+	Seq    uint64    `json:"seq"`
+	Time   time.Time `json:"time"`
+	Kind   EntryKind `json:"kind"`
+	Amount int       `json:"amount"`
+	Lines  []Posting `json:"lines"`
+	FX     *FXQuote  `json:"fx,omitempty"`
+}
+
+// transactionsJSON is an account's history. After a checkpoint the entries
+// up to CompactedThrough are no longer held individually, so for an account
+// opened by then Transactions is incomplete and CompactedThrough is set.
+type transactionsJSON struct {
+	AccountID        int               `json:"account_id"`
+	CompactedThrough uint64            `json:"compacted_through,omitempty"`
+	Transactions     []transactionJSON `json:"transactions"`
+}
+
+// transferJSON is the outcome of a transfer. A transfer held for review by
+// the fraud engine is "held", with the hold to capture or release.
+type transferJSON struct {
+	From   int    `json:"from"`
+	To     int    `json:"to"`
+	Amount int    `json:"amount"`
+	Status string `json:"status"`
+	HoldID uint64 `json:"hold_id,omitempty"`
+	Rule   string `json:"rule,omitempty"`
+}
+
+// NewHandler returns an http.Handler serving the bank's JSON API:
+//
+//	POST /accounts                     {"currency": "USD", "initial_balance": 1000}
+//	GET  /accounts/{id}
+//	GET  /accounts/{id}/transactions
+//	POST /transfers                    {"from": 1, "to": 2, "amount": 100}
+//	POST /holds/{id}/capture           {"to": 2, "amount": 100}
+//	POST /holds/{id}/release
+//	GET  /total
+//
+// Transfers honour an Idempotency-Key header. A transfer held for review
+// returns 202 Accepted with its hold ID; a reviewer approves it by
+// capturing the hold or declines it by releasing it. Failures are returned
+// as {"error": {"code": ..., "message": ...}} with a matching HTTP status.
+func NewHandler(b *Bank) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /accounts", api(b.handleCreateAccount))
+	mux.HandleFunc("GET /accounts/{id}", api(b.handleGetAccount))
+	mux.HandleFunc("GET /accounts/{id}/transactions", api(b.handleTransactions))
+	mux.HandleFunc("POST /transfers", api(b.handleTransfer))
+	mux.HandleFunc("POST /holds/{id}/capture", api(b.handleCapture))
+	mux.HandleFunc("POST /holds/{id}/release", api(b.handleRelease))
+	mux.HandleFunc("GET /total", api(b.handleTotal))
+	return mux
+}
+
+// api adapts a handler returning a value or an error to http.HandlerFunc
+func api(h func(r *http.Request) (int, any, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status, body, err := h(r)
+		if err != nil {
+			status, body = errorResponse(err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		if err := json.NewEncoder(w).Encode(body); err != nil {
+			log.Printf("API: writing response: %v\n", err)
+		}
+	}
+}
+
+// errorResponse builds the response for err
+func errorResponse(err error) (int, apiError) {
+	var body apiError
+	body.Error.Message = err.Error()
+
+	var bad badRequest
+	if errors.As(err, &bad) {
+		body.Error.Code = "bad_request"
+		return http.StatusBadRequest, body
+	}
+	for _, e := range apiErrors {
+		if errors.Is(err, e.err) {
+			body.Error.Code = e.code
+			return e.status, body
+		}
+	}
+	body.Error.Code = "internal"
+	return http.StatusInternalServerError, body
+}
+
+// decode reads a JSON request body into v
+func decode(r *http.Request, v any) error {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return badRequest{fmt.Errorf("decoding request: %w", err)}
+	}
+	return nil
+}
+
+// pathID parses the {id} path parameter
+func pathID(r *http.Request) (int, error) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		return 0, badRequest{fmt.Errorf("bad account id %q", r.PathValue("id"))}
+	}
+	return id, nil
+}
+
+// pathHoldID parses the {id} path parameter of a hold
+func pathHoldID(r *http.Request) (uint64, error) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		return 0, badRequest{fmt.Errorf("bad hold id %q", r.PathValue("id"))}
+	}
+	return id, nil
+}
+
+func (b *Bank) handleCreateAccount(r *http.Request) (int, any, error) {
+	var req struct {
+		Currency       Currency `json:"currency"`
+		InitialBalance int      `json:"initial_balance"`
+	}
+	if err := decode(r, &req); err != nil {
+		return 0, nil, err
+	}
+	if req.InitialBalance < 0 {
+		return 0, nil, fmt.Errorf("%w: initial balance %d", ErrInvalidAmount, req.InitialBalance)
+	}
+
+	id, err := b.OpenAccountIn(cmp.Or(req.Currency, DefaultCurrency), req.InitialBalance)
+	if err != nil {
+		return 0, nil, err
+	}
+	account, err := b.describe(id)
+	return http.StatusCreated, account, err
+}
+
+func (b *Bank) handleGetAccount(r *http.Request) (int, any, error) {
+	id, err := pathID(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	account, err := b.describe(id)
+	return http.StatusOK, account, err
+}
+
+// describe returns the current state of account id
+func (b *Bank) describe(id int) (accountJSON, error) {
+	accounts, err := b.lockAccounts([]int{id})
+	if err != nil {
+		return accountJSON{}, err
+	}
+	defer unlockAccounts(accounts)
+
+	a := accounts[0]
+	return accountJSON{
+		ID:        a.id,
+		Currency:  a.currency,
+		Balance:   a.balance,
+		Available: a.available(),
+		Status:    a.status,
+	}, nil
+}
+
+func (b *Bank) handleTransactions(r *http.Request) (int, any, error) {
+	id, err := pathID(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	if _, err := b.describe(id); err != nil {
+		return 0, nil, err
+	}
+
+	h := b.journal.history()
+	resp := transactionsJSON{AccountID: id, Transactions: []transactionJSON{}}
+	if _, ok := h.baseCur[id]; ok {
+		resp.CompactedThrough = h.baseSeq
+	}
+	for _, e := range h.entries {
+		amount, involved := 0, false
+		for _, line := range e.Lines {
+			if line.AccountID == id {
+				amount += line.Amount
+				involved = true
+			}
+		}
+		if involved {
+			resp.Transactions = append(resp.Transactions, transactionJSON{
+				Seq:    e.Seq,
+				Time:   e.Time,
+				Kind:   e.Kind,
+				Amount: amount,
+				Lines:  e.Lines,
+				FX:     e.FX,
+			})
+		}
+	}
+	return http.StatusOK, resp, nil
+}
+
+func (b *Bank) handleTransfer(r *http.Request) (int, any, error) {
+	var req struct {
+		From   int `json:"from"`
+		To     int `json:"to"`
+		Amount int `json:"amount"`
+	}
+	if err := decode(r, &req); err != nil {
+		return 0, nil, err
+	}
+	if req.Amount <= 0 {
+		return 0, nil, fmt.Errorf("%w: %d", ErrInvalidAmount, req.Amount)
+	}
+
+	var err error
+	if key := r.Header.Get("Idempotency-Key"); key != "" {
+		err = b.TransferWithKey(key, req.From, req.To, req.Amount)
+	} else {
+		err = b.transfer(req.From, req.To, req.Amount)
+	}
+
+	resp := transferJSON{From: req.From, To: req.To, Amount: req.Amount, Status: "completed"}
+	var held *HeldError
+	if errors.As(err, &held) {
+		resp.Status, resp.HoldID, resp.Rule = "held", held.HoldID, held.Rule
+		return http.StatusAccepted, resp, nil
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusOK, resp, nil
+}
+
+func (b *Bank) handleCapture(r *http.Request) (int, any, error) {
+	holdID, err := pathHoldID(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	var req struct {
+		To     int `json:"to"`
+		Amount int `json:"amount"`
+	}
+	if err := decode(r, &req); err != nil {
+		return 0, nil, err
+	}
+	if err := b.Capture(holdID, req.To, req.Amount); err != nil {
+		return 0, nil, err
+	}
+	return http.StatusOK, req, nil
+}
+
+func (b *Bank) handleRelease(r *http.Request) (int, any, error) {
+	holdID, err := pathHoldID(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	if err := b.Release(holdID); err != nil {
+		return 0, nil, err
+	}
+	return http.StatusOK, struct {
+		HoldID uint64 `json:"hold_id"`
+	}{holdID}, nil
+}
+
+func (b *Bank) handleTotal(r *http.Request) (int, any, error) {
+	snap := b.Snapshot()
+	return http.StatusOK, struct {
+		Seq    uint64           `json:"seq"`
+		Totals map[Currency]int `json:"totals"`
+	}{snap.Seq, snap.Totals()}, nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newAPIBank returns a bank for the API tests, with:
+//
+//	1: 1000 USD, with 950 held for review as hold 1, payable to 2, and a
+//	   transfer of 10 to 2 made with idempotency key "k1"
+//	2: 0 USD
+//	3: 500 EUR
+//	4: 100 USD, frozen
+//	5: 1000 USD
+//
+// Transfers above 900 are held for review.
+func newAPIBank(t *testing.T) *Bank {
+	t.Helper()
+	b := NewBank()
+	b.SetFraudEngine(NewFraudEngine(10, LargeAmountRule{MinHistory: 1000, Threshold: 900, Period: time.Hour, Do: HoldForReview}))
+	for _, open := range []struct {
+		currency Currency
+		balance  int
+	}{{USD, 1000}, {USD, 0}, {EUR, 500}, {USD, 100}, {USD, 1000}} {
+		if _, err := b.OpenAccountIn(open.currency, open.balance); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.FreezeAccount(4); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.transfer(1, 2, 950); err == nil {
+		t.Fatal("transfer of 950 was not held")
+	}
+	if err := b.TransferWithKey("k1", 1, 2, 10); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestAPIStatusCodes(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		key        string // Idempotency-Key header
+		wantStatus int
+		wantCode   string // error code, for failures
+	}{
+		{"create account", http.MethodPost, "/accounts", `{"currency": "EUR", "initial_balance": 10}`, "", http.StatusCreated, ""},
+		{"create with unknown currency", http.MethodPost, "/accounts", `{"currency": "XYZ"}`, "", http.StatusBadRequest, "unknown_currency"},
+		{"create with negative balance", http.MethodPost, "/accounts", `{"initial_balance": -1}`, "", http.StatusBadRequest, "invalid_amount"},
+		{"create with unknown field", http.MethodPost, "/accounts", `{"balance": 10}`, "", http.StatusBadRequest, "bad_request"},
+		{"create with malformed body", http.MethodPost, "/accounts", `{`, "", http.StatusBadRequest, "bad_request"},
+
+		{"get account", http.MethodGet, "/accounts/1", "", "", http.StatusOK, ""},
+		{"get unknown account", http.MethodGet, "/accounts/99", "", "", http.StatusNotFound, "unknown_account"},
+		{"get negative account", http.MethodGet, "/accounts/-3", "", "", http.StatusNotFound, "unknown_account"},
+		{"get non-numeric account", http.MethodGet, "/accounts/abc", "", "", http.StatusBadRequest, "bad_request"},
+		{"transactions", http.MethodGet, "/accounts/2/transactions", "", "", http.StatusOK, ""},
+		{"transactions of unknown account", http.MethodGet, "/accounts/99/transactions", "", "", http.StatusNotFound, "unknown_account"},
+		{"transactions of negative account", http.MethodGet, "/accounts/-1/transactions", "", "", http.StatusNotFound, "unknown_account"},
+
+		{"transfer", http.MethodPost, "/transfers", `{"from": 1, "to": 2, "amount": 5}`, "", http.StatusOK, ""},
+		{"transfer across currencies", http.MethodPost, "/transfers", `{"from": 5, "to": 3, "amount": 5}`, "", http.StatusUnprocessableEntity, "no_fx_rate"},
+		{"transfer more than available", http.MethodPost, "/transfers", `{"from": 1, "to": 2, "amount": 500}`, "", http.StatusUnprocessableEntity, "insufficient_funds"},
+		{"transfer from frozen account", http.MethodPost, "/transfers", `{"from": 4, "to": 2, "amount": 5}`, "", http.StatusUnprocessableEntity, "account_frozen"},
+		{"transfer to unknown account", http.MethodPost, "/transfers", `{"from": 1, "to": 99, "amount": 5}`, "", http.StatusNotFound, "unknown_account"},
+		{"transfer to negative account", http.MethodPost, "/transfers", `{"from": 1, "to": -2, "amount": 5}`, "", http.StatusNotFound, "unknown_account"},
+		{"transfer from negative account", http.MethodPost, "/transfers", `{"from": -1, "to": 2, "amount": 5}`, "", http.StatusNotFound, "unknown_account"},
+		{"transfer zero", http.MethodPost, "/transfers", `{"from": 1, "to": 2, "amount": 0}`, "", http.StatusBadRequest, "invalid_amount"},
+		{"transfer with malformed body", http.MethodPost, "/transfers", `{"from": "one"}`, "", http.StatusBadRequest, "bad_request"},
+		{"transfer held for review", http.MethodPost, "/transfers", `{"from": 5, "to": 2, "amount": 950}`, "", http.StatusAccepted, ""},
+		{"retry with key", http.MethodPost, "/transfers", `{"from": 1, "to": 2, "amount": 10}`, "k1", http.StatusOK, ""},
+		{"reuse key with other amount", http.MethodPost, "/transfers", `{"from": 1, "to": 2, "amount": 20}`, "k1", http.StatusConflict, "idempotency_conflict"},
+
+		{"capture to another payee", http.MethodPost, "/holds/1/capture", `{"to": 5, "amount": 950}`, "", http.StatusUnprocessableEntity, "wrong_payee"},
+		{"capture", http.MethodPost, "/holds/1/capture", `{"to": 2, "amount": 950}`, "", http.StatusOK, ""},
+		{"capture unknown hold", http.MethodPost, "/holds/99/capture", `{"to": 2, "amount": 1}`, "", http.StatusNotFound, "unknown_hold"},
+		{"capture bad hold id", http.MethodPost, "/holds/-1/capture", `{"to": 2, "amount": 1}`, "", http.StatusBadRequest, "bad_request"},
+		{"release", http.MethodPost, "/holds/1/release", "", "", http.StatusOK, ""},
+		{"release unknown hold", http.MethodPost, "/holds/99/release", "", "", http.StatusNotFound, "unknown_hold"},
+
+		{"total", http.MethodGet, "/total", "", "", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHandler(newAPIBank(t))
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.key != "" {
+				req.Header.Set("Idempotency-Key", tt.key)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d; body %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", ct)
+			}
+			var body apiError
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("body is not JSON: %v", err)
+			}
+			if body.Error.Code != tt.wantCode {
+				t.Errorf("error code = %q, want %q", body.Error.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestAPITransferResponses(t *testing.T) {
+	h := NewHandler(newAPIBank(t))
+	post := func(body string) transferJSON {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/transfers", strings.NewReader(body)))
+		var resp transferJSON
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	if resp := post(`{"from": 1, "to": 2, "amount": 5}`); resp.Status != "completed" || resp.HoldID != 0 {
+		t.Errorf("transfer = %+v, want completed", resp)
+	}
+	if resp := post(`{"from": 5, "to": 2, "amount": 950}`); resp.Status != "held" || resp.HoldID != 2 || resp.Rule != "large-amount" {
+		t.Errorf("held transfer = %+v, want held as hold 2 by large-amount", resp)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/accounts/1", nil))
+	var account accountJSON
+	if err := json.Unmarshal(rec.Body.Bytes(), &account); err != nil {
+		t.Fatal(err)
+	}
+	if want := (accountJSON{ID: 1, Currency: USD, Balance: 985, Available: 35, Status: StatusOpen}); account != want {
+		t.Errorf("account = %+v, want %+v", account, want)
+	}
+}
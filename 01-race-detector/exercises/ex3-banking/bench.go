@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// benchAccounts is the number of accounts each benchmark run creates
+const benchAccounts = 1024
+
+// runBenchmark measures transfer throughput for each combination of shard
+// count and goroutine count. Each goroutine mixes transfers with balance
+// reads, four to one.
+func runBenchmark(shardCounts, goroutineCounts []int, duration time.Duration) {
+	fmt.Printf("%8s %10s %14s\n", "shards", "goroutines", "ops/sec")
+	for _, shards := range shardCounts {
+		for _, goroutines := range goroutineCounts {
+			ops := benchmarkOnce(shards, goroutines, duration)
+			fmt.Printf("%8d %10d %14.0f\n", shards, goroutines, float64(ops)/duration.Seconds())
+		}
+	}
+}
+
+// benchmarkOnce runs one combination and returns the number of operations
+// completed
+func benchmarkOnce(shards, goroutines int, duration time.Duration) int64 {
+	bank := NewShardedBank(shards)
+	ids := make([]int, benchAccounts)
+	for i := range ids {
+		ids[i] = bank.CreateAccount(1000)
+	}
+
+	var ops atomic.Int64
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(seed))
+
+			n := int64(0)
+			defer func() { ops.Add(n) }()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+
+				from := ids[rng.Intn(len(ids))]
+				if n%5 == 4 {
+					bank.GetBalance(from)
+				} else {
+					bank.Transfer(from, ids[rng.Intn(len(ids))], rng.Intn(10)+1)
+				}
+				n++
+			}
+		}(int64(g))
+	}
+
+	time.Sleep(duration)
+	close(stop)
+	wg.Wait()
+	return ops.Load()
+}
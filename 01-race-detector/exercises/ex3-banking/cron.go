@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSpec is a parsed five field cron expression: minute, hour, day of
+// month, month and day of week. Each field is a set of allowed values.
+type cronSpec struct {
+	minute, hour, dom, month, dow uint64
+	domAny, dowAny                bool
+}
+
+// cronFields are the bounds of each field, in order
+var cronFields = [5]struct{ min, max int }{
+	{0, 59}, // minute
+	{0, 23}, // hour
+	{1, 31}, // day of month
+	{1, 12}, // month
+	{0, 7},  // day of week, 0 and 7 are both Sunday
+}
+
+// parseCron parses expressions such as "30 9 * * 1-5" or "*/15 * * * *".
+// Each field is *, a value, a range a-b, or a list of those separated by
+// commas, optionally followed by /step.
+func parseCron(expr string) (*cronSpec, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("cron %q: want %d fields, got %d", expr, len(cronFields), len(fields))
+	}
+
+	var sets [5]uint64
+	for i, field := range fields {
+		for _, part := range strings.Split(field, ",") {
+			set, err := parseCronPart(part, cronFields[i].min, cronFields[i].max)
+			if err != nil {
+				return nil, fmt.Errorf("cron %q: %w", expr, err)
+			}
+			sets[i] |= set
+		}
+	}
+
+	// Sunday is 0 or 7
+	if sets[4]&(1<<7) != 0 {
+		sets[4] |= 1
+	}
+	return &cronSpec{
+		minute: sets[0],
+		hour:   sets[1],
+		dom:    sets[2],
+		month:  sets[3],
+		dow:    sets[4],
+		domAny: fields[2] == "*",
+		dowAny: fields[4] == "*",
+	}, nil
+}
+
+// parseCronPart parses one comma separated part of a field
+func parseCronPart(part string, min, max int) (uint64, error) {
+	rng, stepStr, hasStep := strings.Cut(part, "/")
+	step := 1
+	if hasStep {
+		var err error
+		if step, err = strconv.Atoi(stepStr); err != nil || step <= 0 {
+			return 0, fmt.Errorf("bad step %q", stepStr)
+		}
+	}
+
+	lo, hi := min, max
+	if rng != "*" {
+		loStr, hiStr, isRange := strings.Cut(rng, "-")
+		var err error
+		if lo, err = strconv.Atoi(loStr); err != nil {
+			return 0, fmt.Errorf("bad value %q", loStr)
+		}
+		hi = lo
+		if isRange {
+			if hi, err = strconv.Atoi(hiStr); err != nil {
+				return 0, fmt.Errorf("bad value %q", hiStr)
+			}
+		} else if hasStep {
+			hi = max
+		}
+	}
+	if lo < min || hi > max || lo > hi {
+		return 0, fmt.Errorf("%q out of range %d-%d", part, min, max)
+	}
+
+	var set uint64
+	for v := lo; v <= hi; v += step {
+		set |= 1 << v
+	}
+	return set, nil
+}
+
+// dayMatches applies cron's rule that when both day of month and day of
+// week are restricted, a day matching either is allowed
+func (c *cronSpec) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<t.Day()) != 0
+	dow := c.dow&(1<<int(t.Weekday())) != 0
+	switch {
+	case c.domAny:
+		return dow
+	case c.dowAny:
+		return dom
+	}
+	return dom || dow
+}
+
+// next returns the first matching minute strictly after t, or false if
+// there is none within five years
+func (c *cronSpec) next(t time.Time) (time.Time, bool) {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		switch {
+		case c.month&(1<<int(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case c.hour&(1<<t.Hour()) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case c.minute&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// RuleAction is what happens when a fraud rule fires
+type RuleAction int
+
+const (
+	// Alert lets the transfer through and reports it on Alerts
+	Alert RuleAction = iota
+	// HoldForReview places a hold for the amount instead of transferring;
+	// approve with Capture or decline with Release
+	HoldForReview
+	// Reject refuses the transfer
+	Reject
+)
+
+func (a RuleAction) String() string {
+	switch a {
+	case HoldForReview:
+		return "hold"
+	case Reject:
+		return "reject"
+	}
+	return "alert"
+}
+
+var (
+	ErrTransferRejected = errors.New("transfer rejected")
+	ErrTransferHeld     = errors.New("transfer held for review")
+)
+
+// HeldError is returned for a transfer held for review. Approve it with
+// Capture(HoldID, to, amount) or decline it with Release(HoldID).
+type HeldError struct {
+	Rule   string
+	HoldID uint64
+}
+
+func (e *HeldError) Error() string {
+	return fmt.Sprintf("rule %s: %v as hold %d", e.Rule, ErrTransferHeld, e.HoldID)
+}
+
+func (e *HeldError) Unwrap() error { return ErrTransferHeld }
+
+// TransferEvent is one transfer seen by the fraud engine
+type TransferEvent struct {
+	Time     time.Time
+	From, To int
+	Amount   int
+}
+
+// FraudRule decides whether a transfer looks suspicious given the recent
+// history of committed transfers, oldest first
+type FraudRule interface {
+	Name() string
+	Action() RuleAction
+	Window() time.Duration
+	Fires(t TransferEvent, history []TransferEvent) bool
+}
+
+// VelocityRule fires when an account sends more than Max transfers within
+// Period
+type VelocityRule struct {
+	Max    int
+	Period time.Duration
+	Do     RuleAction
+}
+
+func (r VelocityRule) Name() string          { return "velocity" }
+func (r VelocityRule) Action() RuleAction    { return r.Do }
+func (r VelocityRule) Window() time.Duration { return r.Period }
+
+func (r VelocityRule) Fires(t TransferEvent, history []TransferEvent) bool {
+	count := 1
+	for _, e := range history {
+		if e.From == t.From && t.Time.Sub(e.Time) <= r.Period {
+			count++
+		}
+	}
+	return count > r.Max
+}
+
+// LargeAmountRule fires when an account sends more than Factor times its
+// average transfer over Period. Accounts with fewer than MinHistory
+// transfers in Period are judged against Threshold instead, if set.
+type LargeAmountRule struct {
+	Factor     int
+	MinHistory int
+	Threshold  int
+	Period     time.Duration
+	Do         RuleAction
+}
+
+func (r LargeAmountRule) Name() string          { return "large-amount" }
+func (r LargeAmountRule) Action() RuleAction    { return r.Do }
+func (r LargeAmountRule) Window() time.Duration { return r.Period }
+
+func (r LargeAmountRule) Fires(t TransferEvent, history []TransferEvent) bool {
+	sum, n := 0, 0
+	for _, e := range history {
+		if e.From == t.From && t.Time.Sub(e.Time) <= r.Period {
+			sum += e.Amount
+			n++
+		}
+	}
+	if n < max(r.MinHistory, 1) {
+		return r.Threshold > 0 && t.Amount > r.Threshold
+	}
+	return t.Amount*n > r.Factor*sum
+}
+
+// CircularRule fires when a transfer closes a cycle of transfers among at
+// most MaxAccounts accounts within Period, such as A to B, B to C, C to A
+type CircularRule struct {
+	MaxAccounts int
+	Period      time.Duration
+	Do          RuleAction
+}
+
+func (r CircularRule) Name() string          { return "circular" }
+func (r CircularRule) Action() RuleAction    { return r.Do }
+func (r CircularRule) Window() time.Duration { return r.Period }
+
+func (r CircularRule) Fires(t TransferEvent, history []TransferEvent) bool {
+	if t.From == t.To {
+		return false
+	}
+	next := make(map[int][]int)
+	for _, e := range history {
+		if t.Time.Sub(e.Time) <= r.Period {
+			next[e.From] = append(next[e.From], e.To)
+		}
+	}
+
+	// Search for a path from t.To back to t.From using at most
+	// MaxAccounts-1 transfers
+	frontier, seen := []int{t.To}, map[int]bool{t.To: true}
+	for hops := 1; hops < r.MaxAccounts && len(frontier) > 0; hops++ {
+		var grown []int
+		for _, id := range frontier {
+			for _, to := range next[id] {
+				if to == t.From {
+					return true
+				}
+				if !seen[to] {
+					seen[to] = true
+					grown = append(grown, to)
+				}
+			}
+		}
+		frontier = grown
+	}
+	return false
+}
+
+// FraudAlert reports a rule firing on a transfer
+type FraudAlert struct {
+	Rule   string
+	Action RuleAction
+	From   int
+	To     int
+	Amount int
+	Time   time.Time
+	HoldID uint64 // set when Action is HoldForReview
+}
+
+// FraudEngine checks every transfer and capture against its rules before it
+// commits.
+// Every rule that fires is reported on Alerts; Reject and HoldForReview
+// also act on the transfer synchronously.
+type FraudEngine struct {
+	rules  []FraudRule
+	alerts chan FraudAlert
+
+	mu      sync.Mutex // taken after any account locks
+	window  time.Duration
+	history []TransferEvent
+	dropped int
+}
+
+// NewFraudEngine returns an engine applying rules. Alerts are buffered up to
+// alertBuffer; when the buffer is full further alerts are dropped and
+// logged, never blocking transfers.
+func NewFraudEngine(alertBuffer int, rules ...FraudRule) *FraudEngine {
+	e := &FraudEngine{rules: rules, alerts: make(chan FraudAlert, alertBuffer)}
+	for _, r := range rules {
+		e.window = max(e.window, r.Window())
+	}
+	return e
+}
+
+// Alerts returns the channel alerts are delivered on
+func (e *FraudEngine) Alerts() <-chan FraudAlert {
+	return e.alerts
+}
+
+// evaluate returns the rules t fires, and the strongest action among them
+func (e *FraudEngine) evaluate(t TransferEvent) ([]FraudRule, RuleAction) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	// Forget transfers no rule can see any more
+	drop := 0
+	for drop < len(e.history) && t.Time.Sub(e.history[drop].Time) > e.window {
+		drop++
+	}
+	e.history = e.history[drop:]
+
+	var fired []FraudRule
+	action := Alert
+	for _, r := range e.rules {
+		if r.Fires(t, e.history) {
+			fired = append(fired, r)
+			action = max(action, r.Action())
+		}
+	}
+	return fired, action
+}
+
+// observe adds a committed transfer to the history
+func (e *FraudEngine) observe(t TransferEvent) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.history = append(e.history, t)
+}
+
+// alert delivers a without blocking
+func (e *FraudEngine) alert(a FraudAlert) {
+	select {
+	case e.alerts <- a:
+	default:
+		e.mu.Lock()
+		e.dropped++
+		dropped := e.dropped
+		e.mu.Unlock()
+		log.Printf("Fraud: alert buffer full, dropped %d alerts\n", dropped)
+	}
+}
+
+// SetFraudEngine installs e to check every Transfer; nil removes it
+func (b *Bank) SetFraudEngine(e *FraudEngine) {
+	b.fraud.Store(e)
+}
+
+// transferEvents describes an entry to the fraud engine: one transfer per
+// debited account, of everything it pays, to the account it credits most.
+// simple reports whether the entry is a plain transfer between two accounts
+// in one currency, which a hold can stand in for.
+func transferEvents(entry JournalEntry, now time.Time) (events []TransferEvent, simple bool) {
+	var ids []int
+	net := make(map[int]int)
+	for _, line := range entry.Lines {
+		if line.AccountID == EquityAccount {
+			continue
+		}
+		if _, ok := net[line.AccountID]; !ok {
+			ids = append(ids, line.AccountID)
+		}
+		net[line.AccountID] += line.Amount
+	}
+
+	to, creditors := EquityAccount, 0
+	for _, id := range ids {
+		if net[id] > 0 {
+			creditors++
+			if to == EquityAccount || net[id] > net[to] {
+				to = id
+			}
+		}
+	}
+	for _, id := range ids {
+		if net[id] < 0 {
+			events = append(events, TransferEvent{Time: now, From: id, To: to, Amount: -net[id]})
+		}
+	}
+	return events, entry.Kind == EntryTransfer && entry.FX == nil && len(events) == 1 && creditors == 1
+}
+
+// observe adds an entry posted without screening, such as an approved
+// transfer held for review, to the fraud engine's history
+func (b *Bank) observe(entry JournalEntry) {
+	e := b.fraud.Load()
+	if e == nil {
+		return
+	}
+	events, _ := transferEvents(entry, time.Now())
+	for _, t := range events {
+		e.observe(t)
+	}
+}
+
+// screen runs the fraud engine over a transfer or capture about to be
+// posted. Caller holds the locks of every account in byID. It returns nil
+// if the entry may be posted; otherwise it has been rejected or held and
+// must not be. HoldForReview can only hold a simple transfer (see
+// transferEvents) and rejects anything else. commit must be called once the
+// entry has been posted.
+func (b *Bank) screen(entry JournalEntry, byID map[int]*Account) (commit func(), err error) {
+	e := b.fraud.Load()
+	if e == nil {
+		return func() {}, nil
+	}
+
+	events, simple := transferEvents(entry, time.Now())
+	for _, t := range events {
+		fired, action := e.evaluate(t)
+
+		// Name the first rule calling for the action taken
+		var rule string
+		for _, r := range fired {
+			if r.Action() == action {
+				rule = r.Name()
+				break
+			}
+		}
+
+		var holdID uint64
+		switch {
+		case len(fired) == 0:
+		case action == Reject, action == HoldForReview && !simple:
+			err = fmt.Errorf("rule %s: %w", rule, ErrTransferRejected)
+		case action == HoldForReview:
+			holdID, err = b.placeHold(byID[t.From], t.Amount, 0, t.To)
+			if err == nil {
+				err = &HeldError{Rule: rule, HoldID: holdID}
+			}
+		}
+
+		for _, r := range fired {
+			e.alert(FraudAlert{
+				Rule:   r.Name(),
+				Action: r.Action(),
+				From:   t.From,
+				To:     t.To,
+				Amount: t.Amount,
+				Time:   t.Time,
+				HoldID: holdID,
+			})
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return func() {
+		for _, t := range events {
+			e.observe(t)
+		}
+	}, nil
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFraudScreensEveryPath(t *testing.T) {
+	tests := []struct {
+		name    string
+		action  RuleAction
+		move    func(b *Bank, from, to int) error
+		wantErr error
+		want    int // balance of from afterwards
+	}{
+		{
+			name:   "transfer under the threshold",
+			action: Reject,
+			move:   func(b *Bank, from, to int) error { return b.transfer(from, to, 100) },
+			want:   900,
+		},
+		{
+			name:    "transfer rejected",
+			action:  Reject,
+			move:    func(b *Bank, from, to int) error { return b.transfer(from, to, 600) },
+			wantErr: ErrTransferRejected,
+			want:    1000,
+		},
+		{
+			name:   "batch rejected",
+			action: Reject,
+			move: func(b *Bank, from, to int) error {
+				return b.TransferMany([]Posting{
+					{AccountID: from, Amount: -600},
+					{AccountID: to, Amount: 600},
+				})
+			},
+			wantErr: ErrTransferRejected,
+			want:    1000,
+		},
+		{
+			name:   "capture rejected",
+			action: Reject,
+			move: func(b *Bank, from, to int) error {
+				holdID, err := b.Authorize(from, 600, time.Minute)
+				if err != nil {
+					return err
+				}
+				return b.Capture(holdID, to, 600)
+			},
+			wantErr: ErrTransferRejected,
+			want:    1000,
+		},
+		{
+			name:    "transfer held",
+			action:  HoldForReview,
+			move:    func(b *Bank, from, to int) error { return b.transfer(from, to, 600) },
+			wantErr: ErrTransferHeld,
+			want:    1000,
+		},
+		{
+			name:   "split batch cannot be held, so it is rejected",
+			action: HoldForReview,
+			move: func(b *Bank, from, to int) error {
+				other, _ := b.OpenAccount(0)
+				return b.TransferMany([]Posting{
+					{AccountID: from, Amount: -600},
+					{AccountID: to, Amount: 300},
+					{AccountID: other, Amount: 300},
+				})
+			},
+			wantErr: ErrTransferRejected,
+			want:    1000,
+		},
+		{
+			name:   "held transfer approved",
+			action: HoldForReview,
+			move: func(b *Bank, from, to int) error {
+				err := b.transfer(from, to, 600)
+				var held *HeldError
+				if !errors.As(err, &held) {
+					return err
+				}
+				return b.Capture(held.HoldID, to, 600)
+			},
+			want: 400,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBank()
+			from, _ := b.OpenAccount(1000)
+			to, _ := b.OpenAccount(0)
+			b.SetFraudEngine(NewFraudEngine(10, LargeAmountRule{
+				Threshold: 500,
+				Period:    time.Minute,
+				Do:        tt.action,
+			}))
+
+			err := tt.move(b, from, to)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if got := b.GetBalance(from); got != tt.want {
+				t.Errorf("balance = %d, want %d", got, tt.want)
+			}
+			if got := b.GetBalance(to); got != 1000-tt.want {
+				t.Errorf("destination balance = %d, want %d", got, 1000-tt.want)
+			}
+			if diffs, err := b.Reconcile(); err != nil || len(diffs) > 0 {
+				t.Errorf("Reconcile: %v, %v", diffs, err)
+			}
+		})
+	}
+}
+
+func TestHeldTransferReservesFunds(t *testing.T) {
+	b := NewBank()
+	from, _ := b.OpenAccount(1000)
+	to, _ := b.OpenAccount(0)
+	b.SetFraudEngine(NewFraudEngine(10, LargeAmountRule{Threshold: 500, Period: time.Minute, Do: HoldForReview}))
+
+	err := b.transfer(from, to, 600)
+	var held *HeldError
+	if !errors.As(err, &held) {
+		t.Fatalf("error = %v, want a HeldError", err)
+	}
+	if available, _ := b.Available(from); available != 400 {
+		t.Errorf("available = %d, want 400", available)
+	}
+	if err := b.Release(held.HoldID); err != nil {
+		t.Fatal(err)
+	}
+	if available, _ := b.Available(from); available != 1000 {
+		t.Errorf("available after release = %d, want 1000", available)
+	}
+}
+
+func TestCaptureReviewHold(t *testing.T) {
+	tests := []struct {
+		name    string
+		payee   func(to, other int) int
+		wantErr error
+	}{
+		{"to the reviewed payee", func(to, _ int) int { return to }, nil},
+		{"to another account", func(_, other int) int { return other }, ErrWrongPayee},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBank()
+			from, _ := b.OpenAccount(1000)
+			to, _ := b.OpenAccount(0)
+			other, _ := b.OpenAccount(0)
+			b.SetFraudEngine(NewFraudEngine(10, LargeAmountRule{Threshold: 400, Period: time.Minute, Do: HoldForReview}))
+
+			var held *HeldError
+			if err := b.transfer(from, to, 500); !errors.As(err, &held) {
+				t.Fatalf("error = %v, want a HeldError", err)
+			}
+			err := b.Capture(held.HoldID, tt.payee(to, other), 500)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Capture error = %v, want %v", err, tt.wantErr)
+			}
+
+			wantFrom, wantTo := 500, 500
+			if tt.wantErr != nil {
+				wantFrom, wantTo = 1000, 0
+				if available, _ := b.Available(from); available != 500 {
+					t.Errorf("available = %d, want the hold kept", available)
+				}
+			}
+			if b.GetBalance(from) != wantFrom || b.GetBalance(to) != wantTo || b.GetBalance(other) != 0 {
+				t.Errorf("balances %d, %d, %d; want %d, %d, 0",
+					b.GetBalance(from), b.GetBalance(to), b.GetBalance(other), wantFrom, wantTo)
+			}
+		})
+	}
+}
+
+func TestApprovedTransferJoinsHistory(t *testing.T) {
+	b := NewBank()
+	from, _ := b.OpenAccount(1000)
+	to, _ := b.OpenAccount(0)
+	engine := NewFraudEngine(10,
+		LargeAmountRule{Threshold: 400, Period: time.Minute, Do: HoldForReview},
+		VelocityRule{Max: 1, Period: time.Minute, Do: Reject},
+	)
+	b.SetFraudEngine(engine)
+
+	var held *HeldError
+	if err := b.transfer(from, to, 500); !errors.As(err, &held) {
+		t.Fatalf("error = %v, want a HeldError", err)
+	}
+	if err := b.Capture(held.HoldID, to, 500); err != nil {
+		t.Fatal(err)
+	}
+
+	// The approved transfer counts towards the velocity limit
+	if err := b.transfer(from, to, 10); !errors.Is(err, ErrTransferRejected) {
+		t.Errorf("second transfer error = %v, want %v", err, ErrTransferRejected)
+	}
+}
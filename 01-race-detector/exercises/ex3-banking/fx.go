@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"sync"
+)
+
+// Currency is an ISO 4217 currency code. Every amount in the bank is an
+// integer number of the currency's minor units, such as cents.
+type Currency string
+
+const (
+	USD Currency = "USD"
+	EUR Currency = "EUR"
+	GBP Currency = "GBP"
+	JPY Currency = "JPY"
+
+	// DefaultCurrency is used by OpenAccount and for journal lines written
+	// before accounts had a currency
+	DefaultCurrency = USD
+)
+
+// minorUnits is the number of decimal places in each currency's minor unit
+var minorUnits = map[Currency]int{
+	USD: 2,
+	EUR: 2,
+	GBP: 2,
+	JPY: 0,
+}
+
+var (
+	ErrUnknownCurrency  = errors.New("unknown currency")
+	ErrCurrencyMismatch = errors.New("currency does not match account")
+	ErrNoRate           = errors.New("no FX rate")
+)
+
+// FXQuote is the rate a cross-currency transfer was converted at. Rate is
+// the exact price of one major unit of From in major units of To, such as
+// "23/25" for 0.92.
+type FXQuote struct {
+	From Currency
+	To   Currency
+	Rate string
+}
+
+// Convert returns amount minor units of q.From in minor units of q.To,
+// rounded half away from zero
+func (q FXQuote) Convert(amount int) (int, error) {
+	rate, ok := new(big.Rat).SetString(q.Rate)
+	if !ok {
+		return 0, fmt.Errorf("bad FX rate %q", q.Rate)
+	}
+
+	// Scale between the two currencies' minor units
+	shift := minorUnits[q.To] - minorUnits[q.From]
+	scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(max(shift, -shift))), nil)
+	v := new(big.Rat).Mul(big.NewRat(int64(amount), 1), rate)
+	if shift >= 0 {
+		v.Mul(v, new(big.Rat).SetInt(scale))
+	} else {
+		v.Quo(v, new(big.Rat).SetInt(scale))
+	}
+
+	return roundRat(v)
+}
+
+// roundRat rounds v to the nearest integer, halves away from zero. It fails
+// with ErrInvalidAmount if the result does not fit in an int.
+func roundRat(v *big.Rat) (int, error) {
+	num, den := new(big.Int).Abs(v.Num()), v.Denom()
+	n, r := new(big.Int).QuoRem(num, den, new(big.Int))
+	if r.Lsh(r, 1).Cmp(den) >= 0 {
+		n.Add(n, big.NewInt(1))
+	}
+	if v.Sign() < 0 {
+		n.Neg(n)
+	}
+	if !n.IsInt64() || n.Int64() > math.MaxInt || n.Int64() < math.MinInt {
+		return 0, fmt.Errorf("%w: %s does not fit in an amount", ErrInvalidAmount, n)
+	}
+	return int(n.Int64()), nil
+}
+
+// FXTable holds exchange rates. Rates can be changed while transfers are
+// running; each transfer converts at the rate current when it commits.
+type FXTable struct {
+	mu    sync.RWMutex
+	rates map[[2]Currency]*big.Rat
+}
+
+// SetRate sets the price of one major unit of from in major units of to.
+// rate is a decimal such as "0.92" or a fraction such as "23/25". The
+// inverse rate is not implied and must be set separately.
+func (t *FXTable) SetRate(from, to Currency, rate string) error {
+	for _, c := range []Currency{from, to} {
+		if _, ok := minorUnits[c]; !ok {
+			return fmt.Errorf("%w: %s", ErrUnknownCurrency, c)
+		}
+	}
+	r, ok := new(big.Rat).SetString(rate)
+	if !ok || r.Sign() <= 0 {
+		return fmt.Errorf("bad FX rate %q", rate)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.rates == nil {
+		t.rates = make(map[[2]Currency]*big.Rat)
+	}
+	t.rates[[2]Currency{from, to}] = r
+	return nil
+}
+
+// Quote returns the current rate from one currency to another
+func (t *FXTable) Quote(from, to Currency) (FXQuote, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	r, ok := t.rates[[2]Currency{from, to}]
+	if !ok {
+		return FXQuote{}, fmt.Errorf("%w: %s to %s", ErrNoRate, from, to)
+	}
+	return FXQuote{From: from, To: to, Rate: r.RatString()}, nil
+}
+
+// FX returns the bank's exchange rate table
+func (b *Bank) FX() *FXTable {
+	return &b.fx
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"maps"
+	"math"
+	"testing"
+)
+
+func TestFXQuoteConvert(t *testing.T) {
+	tests := []struct {
+		name    string
+		quote   FXQuote
+		amount  int
+		want    int
+		wantErr error
+	}{
+		{"same units", FXQuote{USD, EUR, "0.92"}, 1000, 920, nil},
+		{"round half up", FXQuote{USD, EUR, "1/2"}, 1, 1, nil},
+		{"round half away from zero", FXQuote{USD, EUR, "1/2"}, -1, -1, nil},
+		{"to fewer decimals", FXQuote{USD, JPY, "150"}, 1234, 1851, nil},
+		{"to more decimals", FXQuote{JPY, USD, "1/150"}, 1500, 1000, nil},
+		{"overflow", FXQuote{USD, JPY, "1000"}, math.MaxInt / 2, 0, ErrInvalidAmount},
+		{"negative overflow", FXQuote{USD, JPY, "1000"}, math.MinInt / 2, 0, ErrInvalidAmount},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.quote.Convert(tt.amount)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Convert(%d) error = %v, want %v", tt.amount, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Convert(%d) = %d, want %d", tt.amount, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEquityPerCurrency(t *testing.T) {
+	b := NewBank()
+	usd, _ := b.OpenAccountIn(USD, 10000)
+	eur, _ := b.OpenAccountIn(EUR, 500)
+	if err := b.FX().SetRate(USD, EUR, "0.92"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.transfer(usd, eur, 1000); err != nil {
+		t.Fatal(err)
+	}
+
+	seq := b.journal.LastSeq()
+	equity := b.journal.Equity(seq)
+	want := map[Currency]int{USD: -9000, EUR: -1420}
+	if !maps.Equal(equity, want) {
+		t.Errorf("Equity = %v, want %v", equity, want)
+	}
+	if _, ok := b.journal.Replay(seq)[EquityAccount]; ok {
+		t.Error("Replay includes EquityAccount")
+	}
+	for c, total := range b.Snapshot().Totals() {
+		if total != -equity[c] {
+			t.Errorf("%s: total %d, equity %d", c, total, equity[c])
+		}
+	}
+}
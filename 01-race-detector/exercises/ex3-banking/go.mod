@@ -0,0 +1,3 @@
+module banking
+
+go 1.23
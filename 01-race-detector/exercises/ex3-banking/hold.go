@@ -0,0 +1,272 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	ErrUnknownHold   = errors.New("unknown hold")
+	ErrInvalidAmount = errors.New("invalid amount")
+	ErrWrongPayee    = errors.New("hold was reviewed for another payee")
+)
+
+// hold reserves part of an account's available balance until it is
+// captured, released or expires
+type hold struct {
+	accountID int
+	amount    int
+	expires   time.Time   // zero if the hold never expires
+	timer     *time.Timer // guarded by holdTable.mu
+	review    int         // for a transfer held for review, its destination
+}
+
+// record returns hold id as written to the WAL and snapshots
+func (h *hold) record(id uint64) *holdRecord {
+	return &holdRecord{ID: id, AccountID: h.accountID, Amount: h.amount, Expires: h.expires, Review: h.review}
+}
+
+// holdRecord is an open hold as written to the WAL and snapshots
+type holdRecord struct {
+	ID        uint64    `json:"id"`
+	AccountID int       `json:"account"`
+	Amount    int       `json:"amount"`
+	Expires   time.Time `json:"expires"`
+	Review    int       `json:"review,omitempty"`
+}
+
+// overdraftRecord is an overdraft limit as written to the WAL
+type overdraftRecord struct {
+	AccountID int `json:"account"`
+	Limit     int `json:"limit"`
+}
+
+// holdTable tracks open holds. Its lock is always taken after any account
+// locks, never before.
+type holdTable struct {
+	mu     sync.Mutex
+	nextID uint64
+	open   map[uint64]*hold
+}
+
+// lookup returns hold id. Only its timer may change afterwards.
+func (t *holdTable) lookup(id uint64) (*hold, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	h, ok := t.open[id]
+	if !ok {
+		return nil, fmt.Errorf("hold %d: %w", id, ErrUnknownHold)
+	}
+	return h, nil
+}
+
+// claim removes hold id so that only one of capture, release and expiry
+// settles it. Caller holds the lock of the hold's account.
+func (t *holdTable) claim(id uint64) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	h, ok := t.open[id]
+	if !ok {
+		return fmt.Errorf("hold %d: %w", id, ErrUnknownHold)
+	}
+	delete(t.open, id)
+	if h.timer != nil {
+		h.timer.Stop()
+	}
+	return nil
+}
+
+// Authorize places a hold of amount on an account, reducing its available
+// balance but not its ledger balance. The hold is released automatically
+// after ttl unless it is captured or released first; a ttl of zero never
+// expires. It returns the hold's ID.
+func (b *Bank) Authorize(accountID, amount int, ttl time.Duration) (uint64, error) {
+	if amount <= 0 {
+		return 0, fmt.Errorf("%w: %d", ErrInvalidAmount, amount)
+	}
+
+	accounts, err := b.lockAccounts([]int{accountID})
+	if err != nil {
+		return 0, err
+	}
+	defer unlockAccounts(accounts)
+
+	return b.placeHold(accounts[0], amount, ttl, 0)
+}
+
+// placeHold is Authorize for a caller already holding the account's lock.
+// A non-zero review is the destination of a transfer the fraud engine has
+// held for review.
+func (b *Bank) placeHold(account *Account, amount int, ttl time.Duration, review int) (uint64, error) {
+	if err := account.checkActive(); err != nil {
+		return 0, err
+	}
+	if account.available() < amount {
+		return 0, fmt.Errorf("account %d: %w", account.id, ErrInsufficientFunds)
+	}
+
+	b.holds.mu.Lock()
+	defer b.holds.mu.Unlock()
+	id := b.holds.nextID + 1
+	h := &hold{accountID: account.id, amount: amount, review: review}
+	if ttl > 0 {
+		h.expires = time.Now().Add(ttl)
+	}
+	if err := b.journal.logState(walRecord{Hold: h.record(id)}); err != nil {
+		return 0, err
+	}
+	b.holds.nextID = id
+	account.held += amount
+	b.openHold(id, h)
+	return id, nil
+}
+
+// openHold records hold id and starts its expiry timer. Caller holds
+// b.holds.mu.
+func (b *Bank) openHold(id uint64, h *hold) {
+	if b.holds.open == nil {
+		b.holds.open = make(map[uint64]*hold)
+	}
+	if !h.expires.IsZero() {
+		h.timer = time.AfterFunc(time.Until(h.expires), func() { b.Release(id) })
+	}
+	b.holds.open[id] = h
+}
+
+// Capture settles a hold by transferring amount from the held account to
+// toID. amount may be less than the hold; the remainder is released. A
+// transfer held for review can only be captured to the payee it was
+// reviewed for.
+func (b *Bank) Capture(holdID uint64, toID, amount int) error {
+	h, err := b.holds.lookup(holdID)
+	if err != nil {
+		return err
+	}
+	if amount <= 0 || amount > h.amount {
+		return fmt.Errorf("%w: capturing %d of %d", ErrInvalidAmount, amount, h.amount)
+	}
+	if h.review != 0 && toID != h.review {
+		return fmt.Errorf("hold %d: %w: held for a transfer to %d", holdID, ErrWrongPayee, h.review)
+	}
+	fromID := h.accountID
+
+	accounts, err := b.lockAccounts([]int{fromID, toID})
+	if err != nil {
+		return err
+	}
+	defer unlockAccounts(accounts)
+
+	if err := b.holds.claim(holdID); err != nil {
+		return err
+	}
+
+	from := accounts[0]
+	if from.id != fromID {
+		from = accounts[1]
+	}
+	from.held -= h.amount
+
+	// Approving a transfer held for review does not screen it again, but
+	// the fraud engine still sees it in its history
+	entry := JournalEntry{Kind: EntryCapture, Hold: holdID, Lines: []Posting{
+		{AccountID: fromID, Amount: -amount},
+		{AccountID: toID, Amount: amount},
+	}}
+	_, err = b.post(entry, accounts, h.review == 0)
+	if err != nil {
+		// Put the hold back with its original expiry
+		from.held += h.amount
+		b.holds.mu.Lock()
+		b.openHold(holdID, h)
+		b.holds.mu.Unlock()
+		return err
+	}
+	if h.review != 0 {
+		b.observe(entry)
+	}
+	return nil
+}
+
+// Release cancels a hold, returning its amount to the available balance
+func (b *Bank) Release(holdID uint64) error {
+	h, err := b.holds.lookup(holdID)
+	if err != nil {
+		return err
+	}
+
+	accounts, err := b.lockAccounts([]int{h.accountID})
+	if err != nil {
+		return err
+	}
+	defer unlockAccounts(accounts)
+
+	if err := b.holds.claim(holdID); err != nil {
+		return err
+	}
+	if err := b.journal.logState(walRecord{Release: holdID}); err != nil {
+		// Put the hold back without its timer, so an expiry is not retried
+		// in a loop while the WAL keeps failing
+		b.holds.mu.Lock()
+		b.holds.open[holdID] = h
+		b.holds.mu.Unlock()
+		return err
+	}
+	accounts[0].held -= h.amount
+	return nil
+}
+
+// SetOverdraftLimit lets an account's balance go as far as limit below zero.
+// Lowering the limit never fails; it only stops further debits.
+func (b *Bank) SetOverdraftLimit(accountID, limit int) error {
+	if limit < 0 {
+		return fmt.Errorf("%w: overdraft limit %d", ErrInvalidAmount, limit)
+	}
+
+	accounts, err := b.lockAccounts([]int{accountID})
+	if err != nil {
+		return err
+	}
+	defer unlockAccounts(accounts)
+
+	if err := b.journal.logState(walRecord{Overdraft: &overdraftRecord{accountID, limit}}); err != nil {
+		return err
+	}
+	accounts[0].overdraft = limit
+	return nil
+}
+
+// restoreHolds puts back the holds and overdraft limits recovered by
+// OpenBank. A hold that expired while the bank was down is released.
+func (b *Bank) restoreHolds() {
+	j := &b.journal
+	for id, limit := range j.overdrafts {
+		if account, ok := b.lookup(id); ok {
+			account.overdraft = limit
+		}
+	}
+
+	b.holds.mu.Lock()
+	defer b.holds.mu.Unlock()
+	b.holds.nextID = j.lastHold
+	for id, rec := range j.holds {
+		account, ok := b.lookup(rec.AccountID)
+		if !ok {
+			continue
+		}
+		account.held += rec.Amount
+		b.openHold(id, &hold{accountID: rec.AccountID, amount: rec.Amount, expires: rec.Expires, review: rec.Review})
+	}
+}
+
+// Available returns what an account can spend: its ledger balance less open
+// holds, plus its overdraft limit
+func (b *Bank) Available(accountID int) (int, error) {
+	accounts, err := b.lockAccounts([]int{accountID})
+	if err != nil {
+		return 0, err
+	}
+	defer unlockAccounts(accounts)
+	return accounts[0].available(), nil
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// DefaultIdempotencyRetention is how long a key is remembered unless changed
+// with SetIdempotencyRetention
+const DefaultIdempotencyRetention = 24 * time.Hour
+
+var ErrIdempotencyConflict = errors.New("idempotency key reused with different parameters")
+
+// IdempotencyKey is the key a transfer was made with and the postings it was
+// requested with. It is stored in the transfer's journal entry, so a retry
+// after a restart is still recognised.
+type IdempotencyKey struct {
+	Key      string
+	Postings []Posting
+}
+
+// keyRecord is a committed keyed transfer, as kept in snapshots
+type keyRecord struct {
+	IdempotencyKey
+	Time time.Time
+}
+
+// idempotencyRecord is the outcome of the first transfer made with a key.
+// done is closed once err is set.
+type idempotencyRecord struct {
+	key      string
+	postings []Posting
+	created  time.Time
+	done     chan struct{}
+	err      error
+}
+
+// idempotencyTable remembers transfer keys for a retention window
+type idempotencyTable struct {
+	mu        sync.Mutex
+	retention time.Duration
+	records   map[string]*idempotencyRecord
+	order     []*idempotencyRecord // oldest first
+}
+
+// claim returns the record for key, creating it if this is the first use.
+// first reports whether the caller must run the transfer and then call
+// finish.
+func (t *idempotencyTable) claim(key string, postings []Posting) (rec *idempotencyRecord, first bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	t.prune(now)
+
+	if rec, ok := t.records[key]; ok {
+		return rec, false
+	}
+	if t.records == nil {
+		t.records = make(map[string]*idempotencyRecord)
+	}
+	rec = &idempotencyRecord{
+		key:      key,
+		postings: slices.Clone(postings),
+		created:  now,
+		done:     make(chan struct{}),
+	}
+	t.records[key] = rec
+	t.order = append(t.order, rec)
+	return rec, true
+}
+
+// restore remembers transfers committed with a key before a restart
+func (t *idempotencyTable) restore(keys []keyRecord) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, k := range keys {
+		if _, ok := t.records[k.Key]; ok {
+			continue
+		}
+		if t.records == nil {
+			t.records = make(map[string]*idempotencyRecord)
+		}
+		rec := &idempotencyRecord{
+			key:      k.Key,
+			postings: k.Postings,
+			created:  k.Time,
+			done:     make(chan struct{}),
+		}
+		close(rec.done)
+		t.records[k.Key] = rec
+		t.order = append(t.order, rec)
+	}
+	t.prune(time.Now())
+}
+
+// forget drops rec, so the next use of its key runs the transfer again
+func (t *idempotencyTable) forget(rec *idempotencyRecord) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.records[rec.key] == rec {
+		delete(t.records, rec.key)
+	}
+	t.order = slices.DeleteFunc(t.order, func(other *idempotencyRecord) bool {
+		return other == rec
+	})
+}
+
+// cutoff returns the time before which keys are forgotten. Caller holds
+// t.mu.
+func (t *idempotencyTable) cutoff(now time.Time) time.Time {
+	return now.Add(-cmp.Or(t.retention, DefaultIdempotencyRetention))
+}
+
+// prune forgets finished records older than the retention window. Caller
+// holds t.mu.
+func (t *idempotencyTable) prune(now time.Time) {
+	cutoff := t.cutoff(now)
+
+	n := 0
+	for _, rec := range t.order {
+		// A transfer still running is pruned on a later call
+		if rec.created.After(cutoff) || !isClosed(rec.done) {
+			break
+		}
+		delete(t.records, rec.key)
+		n++
+	}
+	t.order = slices.Delete(t.order, 0, n)
+}
+
+// isClosed reports whether ch has been closed
+func isClosed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+// finish records the outcome of the first transfer made with rec's key and
+// wakes any retries waiting on it
+func (rec *idempotencyRecord) finish(err error) {
+	rec.err = err
+	close(rec.done)
+}
+
+// SetIdempotencyRetention sets how long transfer keys are remembered
+func (b *Bank) SetIdempotencyRetention(d time.Duration) {
+	b.idempotency.mu.Lock()
+	defer b.idempotency.mu.Unlock()
+	b.idempotency.retention = d
+}
+
+// TransferWithKey is Transfer made safe to retry. The first call with a key
+// performs the transfer; later calls with the same key and parameters
+// return the original outcome without moving money again, waiting for it if
+// the first call is still running. Reusing a key with different parameters
+// fails with ErrIdempotencyConflict.
+//
+// Only outcomes that changed something are remembered: a transfer that
+// committed, or that was held for review. After any other failure, such as
+// insufficient funds, a retry with the key makes the transfer afresh. The
+// key is stored with the committed entry, so a durable bank still
+// recognises it after a restart.
+func (b *Bank) TransferWithKey(key string, fromID, toID, amount int) error {
+	params := []Posting{
+		{AccountID: fromID, Amount: -amount},
+		{AccountID: toID, Amount: amount},
+	}
+	return b.withKey(key, params, func(key *IdempotencyKey) error {
+		_, err := b.transferKeyed(fromID, toID, amount, key)
+		return err
+	})
+}
+
+// TransferManyWithKey is TransferMany with an idempotency key, see
+// TransferWithKey
+func (b *Bank) TransferManyWithKey(key string, postings []Posting) error {
+	return b.withKey(key, postings, func(key *IdempotencyKey) error {
+		_, err := b.transferMany(postings, key)
+		return err
+	})
+}
+
+// withKey runs fn for the first use of key and returns its outcome to every
+// later use with the same postings. fn records the key in the entry it
+// commits.
+func (b *Bank) withKey(key string, postings []Posting, fn func(key *IdempotencyKey) error) error {
+	rec, first := b.idempotency.claim(key, postings)
+	if first {
+		rec.finish(fn(&IdempotencyKey{Key: key, Postings: rec.postings}))
+		var held *HeldError
+		if rec.err != nil && !errors.As(rec.err, &held) {
+			b.idempotency.forget(rec)
+		}
+		return rec.err
+	}
+
+	if !slices.Equal(rec.postings, postings) {
+		return fmt.Errorf("key %q: %w", key, ErrIdempotencyConflict)
+	}
+	<-rec.done
+	return rec.err
+}
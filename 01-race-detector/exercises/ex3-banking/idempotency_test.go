@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTransferWithKeySurvivesRestart(t *testing.T) {
+	tests := []struct {
+		name       string
+		checkpoint bool // compact the key's entry into the snapshot
+	}{
+		{"from WAL", false},
+		{"from snapshot", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			b, err := OpenBank(dir, PersistOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			from, _ := b.OpenAccount(1000)
+			to, _ := b.OpenAccount(0)
+			if err := b.TransferWithKey("k1", from, to, 100); err != nil {
+				t.Fatal(err)
+			}
+			if tt.checkpoint {
+				if err := b.Checkpoint(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := b.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			b, err = OpenBank(dir, PersistOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer b.Close()
+			if err := b.TransferWithKey("k1", from, to, 100); err != nil {
+				t.Fatalf("retry after restart: %v", err)
+			}
+			if got := b.GetBalance(from); got != 900 {
+				t.Errorf("balance after retry = %d, want 900", got)
+			}
+			if err := b.TransferWithKey("k1", from, to, 200); !errors.Is(err, ErrIdempotencyConflict) {
+				t.Errorf("reuse with other amount: got %v, want ErrIdempotencyConflict", err)
+			}
+		})
+	}
+}
+
+func TestTransferWithKeyRetriesFailure(t *testing.T) {
+	b := NewBank()
+	from, _ := b.OpenAccount(50)
+	to, _ := b.OpenAccount(0)
+	funder, _ := b.OpenAccount(100)
+
+	if err := b.TransferWithKey("k1", from, to, 100); !errors.Is(err, ErrInsufficientFunds) {
+		t.Fatalf("first attempt: got %v, want ErrInsufficientFunds", err)
+	}
+	if err := b.transfer(funder, from, 100); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.TransferWithKey("k1", from, to, 100); err != nil {
+		t.Fatalf("retry after deposit: %v", err)
+	}
+	if err := b.TransferWithKey("k1", from, to, 100); err != nil {
+		t.Fatalf("second retry: %v", err)
+	}
+	if got := b.GetBalance(to); got != 100 {
+		t.Errorf("balance = %d, want 100", got)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// ErrInvalidPeriod is returned for an accrual period that is not a month
+// ("2026-10") or a day ("2026-10-15")
+var ErrInvalidPeriod = errors.New("invalid accrual period")
+
+// parsePeriod returns the first day of an accrual period and the day after
+// its last
+func parsePeriod(period string) (start, end time.Time, err error) {
+	if start, err := time.Parse("2006-1", period); err == nil {
+		return start, start.AddDate(0, 1, 0), nil
+	}
+	if start, err := time.Parse("2006-1-2", period); err == nil {
+		return start, start.AddDate(0, 0, 1), nil
+	}
+	return time.Time{}, time.Time{}, fmt.Errorf("%w: %q", ErrInvalidPeriod, period)
+}
+
+// InterestRate is what accounts in one currency earn and pay each accrual
+// period
+type InterestRate struct {
+	Annual string // yearly rate as a decimal or fraction, such as "0.035"
+	Fee    int    // flat fee per period, in minor units
+}
+
+// AccrualReport summarizes one Accrue run
+type AccrualReport struct {
+	Period  string
+	Posted  int           // accounts posted for Period
+	Already int           // accounts skipped: Period, or part of it, already posted, or closed
+	Frozen  []int         // accounts skipped while frozen; run Accrue again once unfrozen
+	Failed  map[int]error // accounts not posted; running Accrue again retries them
+}
+
+// Accrue posts interest, less fees, for period to every account. Interest is
+// the account's balance times the annual rate times the period's days/365,
+// rounded to the nearest minor unit. Each account gets one journal entry
+// against EquityAccount.
+//
+// Accounts are locked one at a time, so transfers keep running throughout.
+// A period is a month, such as "2026-10", or a day, such as "2026-10-15".
+// An account is only posted if period starts on or after the day the last
+// period posted to it ended, so no day earns interest twice and running
+// Accrue again for the same period never posts twice, even after a restart.
+// Frozen accounts are skipped and listed in the report.
+func (b *Bank) Accrue(period string, rates map[Currency]InterestRate) (AccrualReport, error) {
+	start, end, err := parsePeriod(period)
+	if err != nil {
+		return AccrualReport{}, err
+	}
+	days := int(end.Sub(start).Hours() / 24)
+	annual := make(map[Currency]*big.Rat, len(rates))
+	for c, rate := range rates {
+		r, ok := new(big.Rat).SetString(rate.Annual)
+		if !ok {
+			return AccrualReport{}, fmt.Errorf("%s: bad interest rate %q", c, rate.Annual)
+		}
+		annual[c] = r
+	}
+
+	report := AccrualReport{Period: period, Failed: make(map[int]error)}
+	for _, id := range b.accountIDs() {
+		posted, err := b.accrue(id, period, start, days, rates, annual)
+		switch {
+		case errors.Is(err, ErrAccountFrozen):
+			report.Frozen = append(report.Frozen, id)
+		case err != nil:
+			report.Failed[id] = err
+		case posted:
+			report.Posted++
+		default:
+			report.Already++
+		}
+	}
+	return report, nil
+}
+
+// accrue posts the interest for period, which starts on start, to one
+// account, reporting false if it was already posted. A frozen account is not
+// posted and returns ErrAccountFrozen.
+func (b *Bank) accrue(id int, period string, start time.Time, days int, rates map[Currency]InterestRate, annual map[Currency]*big.Rat) (bool, error) {
+	accounts, err := b.lockAccounts([]int{id})
+	if err != nil {
+		return false, err
+	}
+	defer unlockAccounts(accounts)
+
+	// Holding the account lock keeps another accrual from posting between
+	// this check and the append
+	account := accounts[0]
+	switch account.status {
+	case StatusClosed:
+		return false, nil
+	case StatusFrozen:
+		return false, ErrAccountFrozen
+	}
+	if last := b.journal.lastAccrual(id); last != "" {
+		_, lastEnd, err := parsePeriod(last)
+		if err != nil {
+			return false, fmt.Errorf("last period posted: %w", err)
+		}
+		if start.Before(lastEnd) {
+			return false, nil
+		}
+	}
+
+	rate, ok := annual[account.currency]
+	if !ok {
+		return false, fmt.Errorf("%w: no interest rate for %s", ErrNoRate, account.currency)
+	}
+	interest := new(big.Rat).Mul(big.NewRat(int64(account.balance), 1), rate)
+	interest.Mul(interest, big.NewRat(int64(days), 365))
+	amount, err := roundRat(interest)
+	if err != nil {
+		return false, err
+	}
+	amount -= rates[account.currency].Fee
+
+	// A zero amount is still posted, so the period is recorded
+	_, err = b.post(JournalEntry{Kind: EntryInterest, Period: period, Lines: []Posting{
+		{AccountID: id, Amount: amount},
+		{AccountID: EquityAccount, Amount: -amount, Currency: account.currency},
+	}}, accounts, false)
+	return err == nil, err
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestAccruePeriods(t *testing.T) {
+	rates := map[Currency]InterestRate{USD: {Annual: "0.365"}}
+
+	tests := []struct {
+		name    string
+		periods []string // accrued in order; the last is checked
+		posted  bool
+	}{
+		{"first period", []string{"2026-10"}, true},
+		{"same period again", []string{"2026-10", "2026-10"}, false},
+		{"earlier period", []string{"2026-10", "2026-09"}, false},
+		{"later year", []string{"2026-12", "2027-01"}, true},
+		{"day within posted month", []string{"2026-10", "2026-10-01"}, false},
+		{"later day within posted month", []string{"2026-10", "2026-10-15"}, false},
+		{"day after posted month", []string{"2026-10", "2026-11-01"}, true},
+		{"month containing posted day", []string{"2026-10-15", "2026-10"}, false},
+		{"day after posted day", []string{"2026-10-15", "2026-10-16"}, true},
+		// Compared as strings, "2026-9" would sort after "2026-10"
+		{"month without leading zero", []string{"2026-10", "2026-9"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBank()
+			if _, err := b.OpenAccount(1000); err != nil {
+				t.Fatal(err)
+			}
+
+			var report AccrualReport
+			for _, period := range tt.periods {
+				var err error
+				if report, err = b.Accrue(period, rates); err != nil {
+					t.Fatalf("Accrue(%q): %v", period, err)
+				}
+			}
+			if got := report.Posted == 1; got != tt.posted {
+				t.Errorf("last period posted = %v, want %v (report %+v)", got, tt.posted, report)
+			}
+		})
+	}
+}
+
+func TestAccrueDaysFromPeriod(t *testing.T) {
+	// 36.5% a year on 1000 is 1 a day
+	rates := map[Currency]InterestRate{USD: {Annual: "0.365"}}
+
+	tests := []struct {
+		period string
+		want   int
+	}{
+		{"2026-10", 31},
+		{"2026-11", 30},
+		{"2028-2", 29},
+		{"2026-10-15", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.period, func(t *testing.T) {
+			b := NewBank()
+			id, _ := b.OpenAccount(1000)
+			if _, err := b.Accrue(tt.period, rates); err != nil {
+				t.Fatal(err)
+			}
+			if got := b.GetBalance(id) - 1000; got != tt.want {
+				t.Errorf("interest = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAccrueRejectsBadPeriod(t *testing.T) {
+	for _, period := range []string{"", "October", "2026-13", "2026/10"} {
+		if _, err := NewBank().Accrue(period, nil); !errors.Is(err, ErrInvalidPeriod) {
+			t.Errorf("Accrue(%q) error = %v, want ErrInvalidPeriod", period, err)
+		}
+	}
+}
+
+func TestAccrueSkipsFrozenAccounts(t *testing.T) {
+	rates := map[Currency]InterestRate{USD: {Annual: "0.365"}}
+	b := NewBank()
+	open, _ := b.OpenAccount(1000)
+	frozen, _ := b.OpenAccount(1000)
+	if err := b.FreezeAccount(frozen); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := b.Accrue("2026-10", rates)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Posted != 1 || !slices.Equal(report.Frozen, []int{frozen}) || len(report.Failed) != 0 {
+		t.Fatalf("report = %+v, want account %d posted and %d frozen", report, open, frozen)
+	}
+
+	// Once unfrozen, running the period again posts it
+	if err := b.UnfreezeAccount(frozen); err != nil {
+		t.Fatal(err)
+	}
+	report, err = b.Accrue("2026-10", rates)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Posted != 1 || report.Already != 1 || len(report.Frozen) != 0 {
+		t.Errorf("report after unfreezing = %+v, want 1 posted and 1 already posted", report)
+	}
+}
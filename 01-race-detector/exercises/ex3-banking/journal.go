@@ -0,0 +1,373 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// EquityAccount is the contra account for money entering or leaving the
+// bank, such as opening deposits, and for currency conversions. It never
+// holds a stored balance; in each currency, its lines net to minus the total
+// of every customer account in that currency.
+const EquityAccount = 0
+
+// EntryKind says which operation produced a journal entry
+type EntryKind string
+
+const (
+	EntryOpen     EntryKind = "open"
+	EntryTransfer EntryKind = "transfer"
+	EntryCapture  EntryKind = "capture"
+	EntryInterest EntryKind = "interest"
+	EntryStatus   EntryKind = "status"
+)
+
+// JournalEntry is one committed, balanced transaction. Its lines always sum
+// to zero in each currency. FX is the rate a cross-currency transfer was
+// converted at, Period the accrual period of an interest entry, and Status
+// the new status of the account in the first line of a status entry. Key is
+// set on a transfer made with an idempotency key, and Hold on a capture, to
+// the hold it settled.
+type JournalEntry struct {
+	Seq    uint64
+	Time   time.Time
+	Kind   EntryKind
+	Lines  []Posting
+	FX     *FXQuote        `json:",omitempty"`
+	Period string          `json:",omitempty"`
+	Status AccountStatus   `json:",omitempty"`
+	Key    *IdempotencyKey `json:",omitempty"`
+	Hold   uint64          `json:",omitempty"`
+}
+
+// clone returns a copy of e that shares no memory with it
+func (e JournalEntry) clone() JournalEntry {
+	e.Lines = slices.Clone(e.Lines)
+	if e.FX != nil {
+		fx := *e.FX
+		e.FX = &fx
+	}
+	if e.Key != nil {
+		key := IdempotencyKey{Key: e.Key.Key, Postings: slices.Clone(e.Key.Postings)}
+		e.Key = &key
+	}
+	return e
+}
+
+// Journal is the append-only record of every committed transaction. After a
+// checkpoint, or recovering from one, entries up to baseSeq are no longer
+// held individually; their net effect is kept in base, and replaying to an
+// earlier seq gives the balances as of baseSeq.
+type Journal struct {
+	mu       sync.RWMutex
+	baseSeq  uint64
+	baseTime time.Time // commit time of entry baseSeq
+	base     map[int]int
+	baseCur  map[int]Currency      // currency of each account in base
+	entries  []JournalEntry        // entries[i].Seq == baseSeq+i+1
+	wal      *WAL                  // nil unless persistence is enabled
+	accrued  map[int]string        // last interest period posted to each account
+	status   map[int]AccountStatus // accounts not open
+	keys     []keyRecord           // committed keyed transfers, oldest first
+
+	// Open holds and overdraft limits, as logged; see logState
+	holds      map[uint64]holdRecord
+	lastHold   uint64 // highest hold ID ever logged
+	overdrafts map[int]int
+	logged     []loggedRecord // logged since the last checkpoint, if persistent
+
+	commits commitOrder // which entries have been applied, see append
+}
+
+// append records entry, assigns it the next sequence number and time, and
+// calls apply with the numbered entry to commit the new balances. If
+// persistence is enabled the entry is durable in the WAL first, and nothing
+// is recorded or applied if writing it fails.
+//
+// Callers hold the locks of every account in lines, so entries touching the
+// same account appear in the order they were committed. The journal lock is
+// only held to number, log and record the entry; apply runs after it is
+// released, under the callers' account locks, so commits touching different
+// accounts apply in parallel. keep is passed on to setBalance. apply
+// returns a function delivering the entry to subscribers, which runs once
+// every earlier entry has been applied, so subscribers see commits in
+// sequence order.
+func (j *Journal) append(entry JournalEntry, apply func(entry JournalEntry, keep uint64) (publish func())) (JournalEntry, error) {
+	j.mu.Lock()
+	entry.Seq = j.baseSeq + uint64(len(j.entries)) + 1
+	entry.Time = time.Now()
+	entry.Lines = slices.Clone(entry.Lines)
+	if j.wal != nil {
+		if err := j.wal.Append(walRecord{Entry: &entry}); err != nil {
+			j.mu.Unlock()
+			return JournalEntry{}, err
+		}
+	}
+	j.entries = append(j.entries, entry)
+	j.index(entry)
+	j.mu.Unlock()
+
+	publish := apply(entry, j.commits.keep())
+	j.commits.finish(entry.Seq, publish)
+	return entry, nil
+}
+
+// index updates what the journal tracks about entries as a whole. Caller
+// holds j.mu.
+func (j *Journal) index(entry JournalEntry) {
+	switch entry.Kind {
+	case EntryInterest:
+		if j.accrued == nil {
+			j.accrued = make(map[int]string)
+		}
+		for _, line := range entry.Lines {
+			if line.AccountID != EquityAccount {
+				j.accrued[line.AccountID] = entry.Period
+			}
+		}
+	case EntryStatus:
+		if j.status == nil {
+			j.status = make(map[int]AccountStatus)
+		}
+		j.status[entry.Lines[0].AccountID] = entry.Status
+	}
+	if entry.Key != nil {
+		j.keys = append(j.keys, keyRecord{*entry.Key, entry.Time})
+	}
+	if entry.Hold != 0 {
+		delete(j.holds, entry.Hold)
+	}
+}
+
+// lastAccrual returns the last interest period posted to an account, or ""
+// if there has been none
+func (j *Journal) lastAccrual(id int) string {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	return j.accrued[id]
+}
+
+// log writes a record that is not a journal entry to the WAL, if
+// persistence is enabled
+func (j *Journal) log(rec walRecord) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.wal == nil {
+		return nil
+	}
+	return j.wal.Append(rec)
+}
+
+// loggedRecord is a hold or overdraft record logged after entry seq
+type loggedRecord struct {
+	seq uint64
+	rec walRecord
+}
+
+// logState writes a hold or overdraft record to the WAL, if persistence is
+// enabled, and then records it. Nothing is recorded if writing it fails.
+// Being ordered with the entries by the journal lock, these records are
+// checkpointed exactly as of the snapshot's sequence number.
+func (j *Journal) logState(rec walRecord) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.wal != nil {
+		if err := j.wal.Append(rec); err != nil {
+			return err
+		}
+		j.logged = append(j.logged, loggedRecord{j.baseSeq + uint64(len(j.entries)), rec})
+	}
+	j.indexState(rec)
+	return nil
+}
+
+// indexState applies a hold or overdraft record. Caller holds j.mu.
+func (j *Journal) indexState(rec walRecord) {
+	switch {
+	case rec.Hold != nil:
+		if j.holds == nil {
+			j.holds = make(map[uint64]holdRecord)
+		}
+		j.holds[rec.Hold.ID] = *rec.Hold
+		j.lastHold = max(j.lastHold, rec.Hold.ID)
+	case rec.Release != 0:
+		delete(j.holds, rec.Release)
+	case rec.Overdraft != nil:
+		if j.overdrafts == nil {
+			j.overdrafts = make(map[int]int)
+		}
+		j.overdrafts[rec.Overdraft.AccountID] = rec.Overdraft.Limit
+	}
+}
+
+// Entries returns every entry held individually, oldest first
+func (j *Journal) Entries() []JournalEntry {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	return slices.Clone(j.entries)
+}
+
+// journalHistory is a copy of everything the journal holds
+type journalHistory struct {
+	baseSeq  uint64
+	baseTime time.Time
+	base     map[int]int
+	baseCur  map[int]Currency
+	entries  []JournalEntry
+}
+
+// history copies the journal, so it can be read without holding j.mu
+func (j *Journal) history() journalHistory {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	h := journalHistory{
+		baseSeq:  j.baseSeq,
+		baseTime: j.baseTime,
+		base:     make(map[int]int, len(j.base)),
+		baseCur:  make(map[int]Currency, len(j.baseCur)),
+		entries:  slices.Clone(j.entries),
+	}
+	for id, amount := range j.base {
+		h.base[id] = amount
+	}
+	for id, c := range j.baseCur {
+		h.baseCur[id] = c
+	}
+	return h
+}
+
+// LastSeq returns the sequence number of the newest entry, or 0 if empty
+func (j *Journal) LastSeq() uint64 {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	return j.baseSeq + uint64(len(j.entries))
+}
+
+// Replay derives every customer account's balance from entries up to and
+// including seq. EquityAccount holds amounts in several currencies, so it is
+// left out; see Equity.
+func (j *Journal) Replay(seq uint64) map[int]int {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	return j.replay(seq)
+}
+
+// replay is Replay for callers already holding j.mu
+func (j *Journal) replay(seq uint64) map[int]int {
+	balances := make(map[int]int, len(j.base))
+	for id, amount := range j.base {
+		// Snapshots written before equity was kept per currency include it
+		if id != EquityAccount {
+			balances[id] = amount
+		}
+	}
+	for _, entry := range j.entries {
+		if entry.Seq > seq {
+			break
+		}
+		for _, line := range entry.Lines {
+			if line.AccountID != EquityAccount {
+				balances[line.AccountID] += line.Amount
+			}
+		}
+	}
+	return balances
+}
+
+// Equity derives EquityAccount's balance in each currency from entries up
+// to and including seq. In every currency it is minus the total of the
+// customer accounts.
+func (j *Journal) Equity(seq uint64) map[Currency]int {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	// Entries balance in every currency, so the equity folded into the base
+	// is what balances the base's customer accounts
+	equity := make(map[Currency]int)
+	for id, amount := range j.base {
+		if id != EquityAccount {
+			equity[cmp.Or(j.baseCur[id], DefaultCurrency)] -= amount
+		}
+	}
+	for _, entry := range j.entries {
+		if entry.Seq > seq {
+			break
+		}
+		for _, line := range entry.Lines {
+			if line.AccountID == EquityAccount {
+				equity[cmp.Or(line.Currency, DefaultCurrency)] += line.Amount
+			}
+		}
+	}
+	return equity
+}
+
+// currencies returns the currency of every account opened up to and
+// including seq. Caller holds j.mu.
+func (j *Journal) currencies(seq uint64) map[int]Currency {
+	currencies := make(map[int]Currency, len(j.baseCur))
+	for id, c := range j.baseCur {
+		currencies[id] = c
+	}
+	for _, entry := range j.entries {
+		if entry.Seq > seq {
+			break
+		}
+		if entry.Kind != EntryOpen {
+			continue
+		}
+		for _, line := range entry.Lines {
+			if line.AccountID == EquityAccount {
+				continue
+			}
+			currencies[line.AccountID] = cmp.Or(line.Currency, DefaultCurrency)
+		}
+	}
+	return currencies
+}
+
+// Journal returns the bank's journal
+func (b *Bank) Journal() *Journal {
+	return &b.journal
+}
+
+// Discrepancy is an account whose stored balance disagrees with the balance
+// replayed from the journal
+type Discrepancy struct {
+	AccountID int
+	Stored    int
+	Replayed  int
+}
+
+// Reconcile replays the journal and compares the result with every stored
+// balance. It returns the accounts that disagree, ordered by ID; an empty
+// result with a nil error means the books are consistent.
+func (b *Bank) Reconcile() ([]Discrepancy, error) {
+	// With every account locked no transfer can commit, so the journal up
+	// to this point matches the stored balances exactly
+	accounts, err := b.lockAccounts(b.accountIDs())
+	if err != nil {
+		return nil, fmt.Errorf("reconcile: %w", err)
+	}
+	defer unlockAccounts(accounts)
+
+	replayed := b.journal.Replay(b.journal.LastSeq())
+
+	var diffs []Discrepancy
+	for _, account := range accounts {
+		if r := replayed[account.id]; r != account.balance {
+			diffs = append(diffs, Discrepancy{
+				AccountID: account.id,
+				Stored:    account.balance,
+				Replayed:  r,
+			})
+		}
+	}
+	slices.SortFunc(diffs, func(x, y Discrepancy) int {
+		return cmp.Compare(x.AccountID, y.AccountID)
+	})
+	return diffs, nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"slices"
+	"sync"
+	"testing"
+)
+
+func TestReconcileFindsMismatches(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper map[int]int // account index -> stored balance to force
+		stray  int         // balance of an account opened behind the journal's back, 0 for none
+		want   []Discrepancy
+	}{
+		{"consistent", nil, 0, nil},
+		{"one account off", map[int]int{1: 75}, 0, []Discrepancy{{2, 75, 50}}},
+		{"ordered by ID", map[int]int{2: 0, 0: 99}, 0, []Discrepancy{{1, 99, 950}, {3, 0, 200}}},
+		{"account not in the journal", nil, 30, []Discrepancy{{4, 30, 0}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBank()
+			var ids []int
+			for _, balance := range []int{1000, 0, 200} {
+				id, err := b.OpenAccount(balance)
+				if err != nil {
+					t.Fatal(err)
+				}
+				ids = append(ids, id)
+			}
+			if err := b.transfer(ids[0], ids[1], 50); err != nil {
+				t.Fatal(err)
+			}
+
+			for i, balance := range tt.tamper {
+				a, _ := b.lookup(ids[i])
+				a.mu.Lock()
+				a.balance = balance
+				a.mu.Unlock()
+			}
+			if tt.stray != 0 {
+				id := int(b.lastID.Add(1))
+				s := b.shardOf(id)
+				s.mu.Lock()
+				s.accounts[id] = &Account{id: id, balance: tt.stray, currency: DefaultCurrency}
+				s.mu.Unlock()
+			}
+
+			diffs, err := b.Reconcile()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(diffs, tt.want) {
+				t.Errorf("Reconcile = %+v, want %+v", diffs, tt.want)
+			}
+		})
+	}
+}
+
+func TestReconcileDuringTransfers(t *testing.T) {
+	b := NewBank()
+	var ids []int
+	for range 8 {
+		id, _ := b.OpenAccount(1000)
+		ids = append(ids, id)
+	}
+
+	var wg sync.WaitGroup
+	for w := range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 200 {
+				b.transfer(ids[(w+i)%len(ids)], ids[(w+2*i+1)%len(ids)], i%30+1)
+			}
+		}()
+	}
+	for range 20 {
+		if diffs, err := b.Reconcile(); err != nil || len(diffs) > 0 {
+			t.Errorf("Reconcile mid-run: %+v, %v", diffs, err)
+		}
+	}
+	wg.Wait()
+	if diffs, err := b.Reconcile(); err != nil || len(diffs) > 0 {
+		t.Errorf("Reconcile after the run: %+v, %v", diffs, err)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// AccountStatus is where an account is in its lifecycle
+type AccountStatus string
+
+const (
+	StatusOpen   AccountStatus = "open"
+	StatusFrozen AccountStatus = "frozen" // no money moves in or out
+	StatusClosed AccountStatus = "closed" // final; the balance is zero
+)
+
+var (
+	ErrAccountFrozen     = errors.New("account is frozen")
+	ErrAccountClosed     = errors.New("account is closed")
+	ErrInvalidTransition = errors.New("invalid status change")
+	ErrNonZeroBalance    = errors.New("balance is not zero")
+	ErrOpenHolds         = errors.New("account has open holds")
+)
+
+// transitions lists the status changes allowed from each status
+var transitions = map[AccountStatus][]AccountStatus{
+	StatusOpen:   {StatusFrozen, StatusClosed},
+	StatusFrozen: {StatusOpen, StatusClosed},
+}
+
+// checkActive returns an error unless money can move in or out of the
+// account. Caller holds a.mu.
+func (a *Account) checkActive() error {
+	switch a.status {
+	case StatusFrozen:
+		return fmt.Errorf("account %d: %w", a.id, ErrAccountFrozen)
+	case StatusClosed:
+		return fmt.Errorf("account %d: %w", a.id, ErrAccountClosed)
+	}
+	return nil
+}
+
+// Status returns an account's lifecycle status
+func (b *Bank) Status(id int) (AccountStatus, error) {
+	accounts, err := b.lockAccounts([]int{id})
+	if err != nil {
+		return "", err
+	}
+	defer unlockAccounts(accounts)
+	return accounts[0].status, nil
+}
+
+// FreezeAccount stops all money moving in or out of an account until
+// UnfreezeAccount
+func (b *Bank) FreezeAccount(id int) error {
+	return b.changeStatus(id, StatusFrozen, 0)
+}
+
+// UnfreezeAccount reopens a frozen account
+func (b *Bank) UnfreezeAccount(id int) error {
+	return b.changeStatus(id, StatusOpen, 0)
+}
+
+// CloseAccount closes an account for good. An account with a balance is
+// first swept to sweepTo, which must be open and in the same currency; pass 0
+// to require a zero balance instead. An overdrawn balance is paid off from
+// sweepTo. Open holds must be captured or released first.
+//
+// The sweep and the close are one journal entry, committed under the
+// account's lock, so no transfer can touch the account after it.
+func (b *Bank) CloseAccount(id, sweepTo int) error {
+	return b.changeStatus(id, StatusClosed, sweepTo)
+}
+
+// changeStatus moves account id to status, sweeping its balance to sweepTo
+// when closing. The change is recorded in the journal, with the account as
+// the first line, so it survives a restart.
+func (b *Bank) changeStatus(id int, status AccountStatus, sweepTo int) error {
+	ids := []int{id}
+	if sweepTo != 0 {
+		ids = append(ids, sweepTo)
+	}
+	accounts, err := b.lockAccounts(ids)
+	if err != nil {
+		return err
+	}
+	defer unlockAccounts(accounts)
+
+	byID := make(map[int]*Account, len(accounts))
+	for _, a := range accounts {
+		byID[a.id] = a
+	}
+	account := byID[id]
+
+	allowed := false
+	for _, to := range transitions[account.status] {
+		allowed = allowed || to == status
+	}
+	if !allowed {
+		return fmt.Errorf("account %d: %w from %s to %s", id, ErrInvalidTransition, account.status, status)
+	}
+
+	lines := []Posting{{AccountID: id, Currency: account.currency}}
+	if status == StatusClosed {
+		if account.held != 0 {
+			return fmt.Errorf("account %d: %w", id, ErrOpenHolds)
+		}
+		if balance := account.balance; balance != 0 {
+			target, ok := byID[sweepTo]
+			switch {
+			case sweepTo == 0:
+				return fmt.Errorf("account %d: %w", id, ErrNonZeroBalance)
+			case !ok || sweepTo == id:
+				return fmt.Errorf("account %d: cannot sweep to itself", id)
+			case target.currency != account.currency:
+				return fmt.Errorf("account %d: %w: %s is not %s", sweepTo, ErrCurrencyMismatch, target.currency, account.currency)
+			}
+			if err := target.checkActive(); err != nil {
+				return err
+			}
+			if balance < 0 && target.available() < -balance {
+				return fmt.Errorf("account %d: %w", sweepTo, ErrInsufficientFunds)
+			}
+			lines = []Posting{
+				{AccountID: id, Amount: -balance, Currency: account.currency},
+				{AccountID: sweepTo, Amount: balance, Currency: account.currency},
+			}
+		}
+	}
+
+	_, err = b.journal.append(JournalEntry{Kind: EntryStatus, Status: status, Lines: lines}, func(entry JournalEntry, keep uint64) func() {
+		for _, line := range lines {
+			if line.Amount != 0 {
+				a := byID[line.AccountID]
+				a.setBalance(entry.Seq, a.balance+line.Amount, keep)
+			}
+		}
+		account.status = status
+		return b.publication(entry, accounts)
+	})
+	return err
+}
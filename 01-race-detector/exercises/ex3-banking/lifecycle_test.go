@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"runtime"
+	"sync"
+	"testing"
+)
+
+func TestChangeStatus(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(b *Bank, id, other int) error
+		change  func(b *Bank, id, other int) error
+		wantErr error
+		want    AccountStatus
+	}{
+		{"freeze", nil, func(b *Bank, id, _ int) error { return b.FreezeAccount(id) }, nil, StatusFrozen},
+		{"unfreeze", func(b *Bank, id, _ int) error { return b.FreezeAccount(id) },
+			func(b *Bank, id, _ int) error { return b.UnfreezeAccount(id) }, nil, StatusOpen},
+		{"unfreeze an open account", nil,
+			func(b *Bank, id, _ int) error { return b.UnfreezeAccount(id) }, ErrInvalidTransition, StatusOpen},
+		{"close with a balance and no sweep", nil,
+			func(b *Bank, id, _ int) error { return b.CloseAccount(id, 0) }, ErrNonZeroBalance, StatusOpen},
+		{"close with open holds", func(b *Bank, id, _ int) error { _, err := b.Authorize(id, 10, 0); return err },
+			func(b *Bank, id, other int) error { return b.CloseAccount(id, other) }, ErrOpenHolds, StatusOpen},
+		{"close sweeping to a frozen account", func(b *Bank, _, other int) error { return b.FreezeAccount(other) },
+			func(b *Bank, id, other int) error { return b.CloseAccount(id, other) }, ErrAccountFrozen, StatusOpen},
+		{"close a frozen account", func(b *Bank, id, _ int) error { return b.FreezeAccount(id) },
+			func(b *Bank, id, other int) error { return b.CloseAccount(id, other) }, nil, StatusClosed},
+		{"reopen a closed account", func(b *Bank, id, other int) error { return b.CloseAccount(id, other) },
+			func(b *Bank, id, _ int) error { return b.UnfreezeAccount(id) }, ErrInvalidTransition, StatusClosed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBank()
+			id, _ := b.OpenAccount(100)
+			other, _ := b.OpenAccount(0)
+			if tt.setup != nil {
+				if err := tt.setup(b, id, other); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := tt.change(b, id, other); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if got, _ := b.Status(id); got != tt.want {
+				t.Errorf("status = %s, want %s", got, tt.want)
+			}
+			if diffs, err := b.Reconcile(); err != nil || len(diffs) > 0 {
+				t.Errorf("Reconcile: %+v, %v", diffs, err)
+			}
+		})
+	}
+}
+
+func TestCloseRacesTransfers(t *testing.T) {
+	for range 20 {
+		b := NewBank()
+		closing, _ := b.OpenAccount(500)
+		sweepTo, _ := b.OpenAccount(0)
+		var peers []int
+		for range 4 {
+			id, _ := b.OpenAccount(500)
+			peers = append(peers, id)
+		}
+
+		// Transfers in and out of the account race its close. Each either
+		// commits before the close, and is swept with the rest of the
+		// balance, or fails because the account is closed.
+		var wg sync.WaitGroup
+		for i, peer := range peers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for n := 0; n < 50; n++ {
+					from, to := peer, closing
+					if (i+n)%2 == 0 {
+						from, to = to, from
+					}
+					if err := b.transfer(from, to, 5); err != nil &&
+						!errors.Is(err, ErrAccountClosed) && !errors.Is(err, ErrInsufficientFunds) {
+						t.Error(err)
+					}
+				}
+			}()
+		}
+		// Close part way through the transfers, not before they start
+		for start := b.journal.LastSeq(); b.journal.LastSeq() < start+10; {
+			runtime.Gosched()
+		}
+		if err := b.CloseAccount(closing, sweepTo); err != nil {
+			t.Fatal(err)
+		}
+		wg.Wait()
+
+		if got := b.GetBalance(closing); got != 0 {
+			t.Fatalf("closed account has balance %d", got)
+		}
+		if total := b.TotalBalance(); total != 2500 {
+			t.Fatalf("total = %d, want 2500", total)
+		}
+		// Nothing touching the account commits after its close
+		closed := false
+		for _, e := range b.journal.Entries() {
+			for _, line := range e.Lines {
+				if line.AccountID != closing {
+					continue
+				}
+				if closed {
+					t.Fatalf("entry %d touches account %d after it closed", e.Seq, closing)
+				}
+				if e.Kind == EntryStatus && e.Status == StatusClosed {
+					closed = true
+				}
+			}
+		}
+		if diffs, err := b.Reconcile(); err != nil || len(diffs) > 0 {
+			t.Fatalf("Reconcile: %+v, %v", diffs, err)
+		}
+	}
+}
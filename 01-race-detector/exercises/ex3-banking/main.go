@@ -1,82 +1,172 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"log"
 	"math/rand"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-// TODO: Fix ALL race conditions in this banking system
 type Account struct {
-	id      int
-	balance int // RACE: Concurrent access to balance
+	mu        sync.Mutex // guards balance, held, overdraft and status
+	id        int
+	currency  Currency
+	status    AccountStatus
+	balance   int                     // minor units of currency
+	held      int                     // reserved by open holds
+	overdraft int                     // how far below zero balance may go
+	versions  atomic.Pointer[version] // committed balances, newest first
+}
+
+// available is what the account can spend: its balance less open holds, plus
+// its overdraft limit. Caller holds a.mu.
+func (a *Account) available() int {
+	return a.balance - a.held + a.overdraft
 }
 
 type Bank struct {
-	accounts map[int]*Account // RACE: Concurrent map access
-	nextID   int             // RACE: Concurrent access to nextID
+	shards      []*shard
+	lastID      atomic.Int64 // most recently allocated account ID
+	journal     Journal
+	persist     *persistence // nil for an in-memory bank
+	holds       holdTable
+	idempotency idempotencyTable
+	fx          FXTable
+	schedules   scheduleTable
+	fraud       atomic.Pointer[FraudEngine]
+	subscribers subscribers
+}
+
+func NewBank() *Bank {
+	return NewShardedBank(DefaultShards)
 }
 
+// CreateAccount opens an account and returns its ID, or -1 if the account
+// could not be recorded. Use OpenAccount to see why.
 func (b *Bank) CreateAccount(initialBalance int) int {
-	// RACE: Multiple goroutines accessing nextID and accounts map
-	id := b.nextID
-	b.nextID++
-	b.accounts[id] = &Account{id: id, balance: initialBalance}
+	id, err := b.OpenAccount(initialBalance)
+	if err != nil {
+		return -1
+	}
 	return id
 }
 
+// OpenAccount opens an account in DefaultCurrency with an opening deposit
+// and returns its ID
+func (b *Bank) OpenAccount(initialBalance int) (int, error) {
+	return b.OpenAccountIn(DefaultCurrency, initialBalance)
+}
+
+// OpenAccountIn opens an account in currency with an opening deposit, in
+// minor units, and returns its ID
+func (b *Bank) OpenAccountIn(currency Currency, initialBalance int) (int, error) {
+	id, _, err := b.openAccount(currency, initialBalance)
+	return id, err
+}
+
+// openAccount is OpenAccountIn, also returning the sequence number of the
+// account's opening entry
+func (b *Bank) openAccount(currency Currency, initialBalance int) (int, uint64, error) {
+	if _, ok := minorUnits[currency]; !ok {
+		return 0, 0, fmt.Errorf("%w: %s", ErrUnknownCurrency, currency)
+	}
+
+	// Hold the shard lock from the journal append until the insert, so the
+	// account is visible to anyone who can see its opening entry
+	account := &Account{id: int(b.lastID.Add(1)), currency: currency, status: StatusOpen}
+	s := b.shardOf(account.id)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	committed, err := b.journal.append(JournalEntry{Kind: EntryOpen, Lines: []Posting{
+		{AccountID: EquityAccount, Amount: -initialBalance, Currency: currency},
+		{AccountID: account.id, Amount: initialBalance, Currency: currency},
+	}}, func(entry JournalEntry, keep uint64) func() {
+		account.setBalance(entry.Seq, initialBalance, keep)
+		return b.publication(entry, []*Account{account})
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	s.accounts[account.id] = account
+	return account.id, committed.Seq, nil
+}
+
 func (b *Bank) GetBalance(id int) int {
-	// RACE: Reading from accounts map and balance
-	if account, ok := b.accounts[id]; ok {
-		return account.balance
+	account, ok := b.lookup(id)
+	if !ok {
+		return 0
 	}
-	return 0
+	account.mu.Lock()
+	defer account.mu.Unlock()
+	return account.balance
 }
 
+// Transfer moves amount, in minor units of the source account's currency,
+// converting it if the destination account uses another currency
 func (b *Bank) Transfer(fromID, toID, amount int) bool {
-	// RACE: Multiple operations on shared data
-	fromAccount, ok1 := b.accounts[fromID]
-	toAccount, ok2 := b.accounts[toID]
-	
-	if !ok1 || !ok2 {
-		return false
-	}
-	
-	if fromAccount.balance >= amount {
-		fromAccount.balance -= amount // RACE: Concurrent balance modification
-		toAccount.balance += amount   // RACE: Concurrent balance modification
-		return true
-	}
-	return false
+	return b.transfer(fromID, toID, amount) == nil
 }
 
+// TotalBalance sums every balance as of a single commit point. It is only
+// meaningful when every account uses the same currency; see Snapshot.Totals.
 func (b *Bank) TotalBalance() int {
-	// RACE: Reading from accounts map and balances
-	total := 0
-	for _, account := range b.accounts {
-		total += account.balance
-	}
-	return total
+	return b.Snapshot().Total()
 }
 
 func simulateTransactions(bank *Bank, wg *sync.WaitGroup) {
 	defer wg.Done()
-	
+
 	// Create accounts
 	accounts := make([]int, 5)
 	for i := 0; i < 5; i++ {
 		accounts[i] = bank.CreateAccount(1000)
 	}
-	
+
 	// Perform random transfers
 	for i := 0; i < 100; i++ {
 		from := accounts[rand.Intn(5)]
 		to := accounts[rand.Intn(5)]
 		amount := rand.Intn(100) + 1
-		
+
 		bank.Transfer(from, to, amount)
-		
+
+		// Occasionally split a payment from one account across two others
+		if i%25 == 0 {
+			a, b := accounts[rand.Intn(5)], accounts[rand.Intn(5)]
+			bank.TransferMany([]Posting{
+				{AccountID: from, Amount: -2 * amount},
+				{AccountID: a, Amount: amount},
+				{AccountID: b, Amount: amount},
+			})
+		}
+
+		// Retry a transfer as a client would after a timeout; the key makes
+		// sure the money only moves once
+		if i%30 == 0 {
+			key := fmt.Sprintf("sim-%d-%d", accounts[0], i)
+			bank.TransferWithKey(key, to, from, amount)
+			bank.TransferWithKey(key, to, from, amount)
+		}
+
+		// Authorize a card payment; capture half of them and let the
+		// rest expire
+		if i%20 == 0 {
+			if hold, err := bank.Authorize(from, amount, 50*time.Millisecond); err == nil && i%40 == 0 {
+				bank.Capture(hold, to, amount)
+			}
+		}
+
 		if i%10 == 0 {
 			balance := bank.GetBalance(from)
 			fmt.Printf("Account %d balance: %d\n", from, balance)
@@ -84,26 +174,156 @@ func simulateTransactions(bank *Bank, wg *sync.WaitGroup) {
 	}
 }
 
+var (
+	dataDir = flag.String("data", "", "store the bank durably in this directory")
+	accrual = flag.String("accrue", "", "post a month of interest for this period, such as 2026-10, while transfers run")
+	serve   = flag.String("serve", "", "serve the JSON HTTP API on this address, such as localhost:8080, instead of simulating")
+	bench   = flag.Bool("bench", false, "report transfer throughput by shard and goroutine count instead of simulating")
+	benchT  = flag.Duration("bench-time", 500*time.Millisecond, "how long each -bench run lasts")
+
+	fraud = flag.Bool("fraud", false, "screen transfers with fraud rules and print their alerts")
+	watch = flag.Int("watch", 0, "print the balance of this account each time it changes")
+
+	statementDir = flag.String("statements", "", "write every account's statement for the run to this directory, as CSV and JSON")
+
+	stress       = flag.Bool("stress", false, "run the invariant-checking stress harness instead of simulating")
+	seed         = flag.Int64("seed", 1, "seed for -stress")
+	creators     = flag.Int("creators", 2, "goroutines opening accounts in -stress")
+	transferrers = flag.Int("transferrers", 8, "goroutines transferring in -stress")
+	readers      = flag.Int("readers", 2, "goroutines checking invariants in -stress")
+	stressOps    = flag.Int("ops", 1000, "operations per goroutine in -stress")
+)
+
+// interestRates are the rates used by -accrue
+var interestRates = map[Currency]InterestRate{
+	USD: {Annual: "0.05", Fee: 1},
+	EUR: {Annual: "0.03", Fee: 1},
+}
+
 func main() {
-	bank := &Bank{
-		accounts: make(map[int]*Account),
-		nextID:   1,
+	flag.Parse()
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run does what the flags ask for. It returns instead of exiting on error,
+// so the bank is always closed cleanly.
+func run() error {
+	if *stress {
+		return runStress(StressConfig{
+			Seed:         *seed,
+			Creators:     *creators,
+			Transferrers: *transferrers,
+			Readers:      *readers,
+			Ops:          *stressOps,
+		}, os.Stdout)
+	}
+
+	if *bench {
+		runBenchmark([]int{1, 4, 16, 64}, []int{1, 4, 16}, *benchT)
+		return nil
+	}
+
+	bank := NewBank()
+	if *dataDir != "" {
+		var err error
+		bank, err = OpenBank(*dataDir, PersistOptions{CheckpointInterval: time.Second})
+		if err != nil {
+			return err
+		}
+		defer bank.Close()
+	}
+
+	if *serve != "" {
+		return serveAPI(bank, *serve)
 	}
-	
+
+	// Pay account 2 from account 1 on a schedule while the simulation runs
+	ctx, stopScheduler := context.WithCancel(context.Background())
+	schedulerDone := make(chan struct{})
+	go func() {
+		defer close(schedulerDone)
+		bank.RunScheduler(ctx)
+	}()
+	defer func() {
+		stopScheduler()
+		<-schedulerDone
+	}()
+	standingOrder, err := bank.ScheduleTransfer(ScheduledTransfer{
+		From:     1,
+		To:       2,
+		Amount:   5,
+		Schedule: Schedule{Every: 20 * time.Millisecond},
+	})
+	if err != nil {
+		return err
+	}
+
 	var wg sync.WaitGroup
-	
+	start := time.Now()
+
 	// Run multiple simulations concurrently
 	for i := 0; i < 3; i++ {
 		wg.Add(1)
 		go simulateTransactions(bank, &wg)
 	}
-	
+
+	// Accrue interest while the simulations run. The error is returned once
+	// they finish, so the bank is still closed cleanly.
+	var accrualErr error
+	if *accrual != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			time.Sleep(10 * time.Millisecond)
+			report, err := bank.Accrue(*accrual, interestRates)
+			if err != nil {
+				accrualErr = err
+				return
+			}
+			fmt.Printf("Accrued %s: %d posted, %d already posted, %d frozen, %d failed\n",
+				report.Period, report.Posted, report.Already, len(report.Frozen), len(report.Failed))
+		}()
+	}
+
+	if *fraud {
+		engine := NewFraudEngine(100,
+			VelocityRule{Max: 20, Period: 50 * time.Millisecond, Do: Reject},
+			LargeAmountRule{Factor: 4, MinHistory: 5, Period: time.Second, Do: HoldForReview},
+			CircularRule{MaxAccounts: 3, Period: 10 * time.Millisecond, Do: Alert},
+		)
+		bank.SetFraudEngine(engine)
+		go func() {
+			for a := range engine.Alerts() {
+				fmt.Printf("Fraud alert: %s (%s) %d -> %d amount %d\n", a.Rule, a.Action, a.From, a.To, a.Amount)
+			}
+		}()
+	}
+
+	if *watch != 0 {
+		sub, err := bank.Subscribe(EventFilter{Accounts: []int{*watch}, Kinds: []EventKind{EventBalance}}, 16, DropWithGap)
+		if err != nil {
+			return err
+		}
+		defer sub.Unsubscribe()
+		go func() {
+			for e := range sub.C {
+				if e.Kind == EventGap {
+					fmt.Printf("Watch: missed %d events from seq %d\n", e.Missed, e.Seq)
+					continue
+				}
+				fmt.Printf("Watch: account %d balance %d at seq %d\n", e.AccountID, e.Balance, e.Seq)
+			}
+		}()
+	}
+
 	// Periodically check total balance
 	done := make(chan struct{})
 	go func() {
 		ticker := time.NewTicker(100 * time.Millisecond)
 		defer ticker.Stop()
-		
+
 		for {
 			select {
 			case <-ticker.C:
@@ -114,9 +334,95 @@ func main() {
 			}
 		}
 	}()
-	
+
 	wg.Wait()
 	close(done)
-	
+	bank.CancelSchedule(standingOrder)
+	stopScheduler()
+	<-schedulerDone
+	if accrualErr != nil {
+		return accrualErr
+	}
+
+	// Statements cover everything the simulations committed
+	if *statementDir != "" {
+		n, err := writeStatements(bank, *statementDir, start, time.Now())
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Wrote %d statements to %s\n", n, *statementDir)
+	}
+
 	fmt.Printf("Final total balance: %d\n", bank.TotalBalance())
+
+	// Move half of the first account into a euro account
+	bank.FX().SetRate(USD, EUR, "0.92")
+	if eur, err := bank.OpenAccountIn(EUR, 0); err == nil {
+		bank.Transfer(1, eur, bank.GetBalance(1)/2)
+		fmt.Printf("Totals by currency: %v\n", bank.Snapshot().Totals())
+	}
+
+	diffs, err := bank.Reconcile()
+	if err != nil {
+		return err
+	}
+	if len(diffs) > 0 {
+		for _, d := range diffs {
+			fmt.Printf("Account %d: stored %d, journal %d\n", d.AccountID, d.Stored, d.Replayed)
+		}
+	} else {
+		fmt.Printf("Journal reconciles: %d entries\n", bank.Journal().LastSeq())
+	}
+	return nil
+}
+
+// writeStatements writes every account's statement for [from, to) to dir,
+// as account-<id>.csv and account-<id>.json, and returns how many it wrote
+func writeStatements(bank *Bank, dir string, from, to time.Time) (int, error) {
+	statements, err := bank.Statements(from, to)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return 0, err
+	}
+
+	write := func(name string, fn func(io.Writer) error) error {
+		f, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			return err
+		}
+		if err := fn(f); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}
+	for _, s := range statements {
+		if err := write(fmt.Sprintf("account-%d.csv", s.AccountID), s.WriteCSV); err != nil {
+			return 0, err
+		}
+		if err := write(fmt.Sprintf("account-%d.json", s.AccountID), s.WriteJSON); err != nil {
+			return 0, err
+		}
+	}
+	return len(statements), nil
+}
+
+// serveAPI serves the bank's HTTP API on addr until interrupted
+func serveAPI(bank *Bank, addr string) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	srv := &http.Server{Addr: addr, Handler: NewHandler(bank)}
+	go func() {
+		<-ctx.Done()
+		srv.Shutdown(context.Background())
+	}()
+
+	log.Printf("Serving the bank API on http://%s\n", addr)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
 }
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+)
+
+var ErrUnknownSchedule = errors.New("unknown schedule")
+
+// Schedule says when a scheduled transfer runs. Exactly one of At, Every
+// and Cron must be set.
+type Schedule struct {
+	At    time.Time     `json:"at"`              // once, at this time
+	Every time.Duration `json:"every,omitempty"` // repeatedly, this far apart
+	Cron  string        `json:"cron,omitempty"`  // five field cron expression, local time
+}
+
+// validate checks that exactly one way of scheduling is used
+func (s Schedule) validate() error {
+	set := 0
+	if !s.At.IsZero() {
+		set++
+	}
+	if s.Every < 0 {
+		return fmt.Errorf("schedule: negative interval %v", s.Every)
+	}
+	if s.Every > 0 {
+		set++
+	}
+	if s.Cron != "" {
+		if _, err := parseCron(s.Cron); err != nil {
+			return err
+		}
+		set++
+	}
+	if set != 1 {
+		return errors.New("schedule: exactly one of At, Every and Cron must be set")
+	}
+	return nil
+}
+
+// first returns the first run time of a new schedule
+func (s Schedule) first(now time.Time) (time.Time, bool) {
+	if !s.At.IsZero() {
+		return s.At, true
+	}
+	return s.after(now, now)
+}
+
+// after returns the next run time once the run due at prev has started at
+// now, or false if the schedule is finished. Runs missed while the bank was
+// down are not repeated; at most one catches up.
+func (s Schedule) after(prev, now time.Time) (time.Time, bool) {
+	switch {
+	case s.Every > 0:
+		next := prev.Add(s.Every)
+		if !next.After(now) {
+			next = next.Add((now.Sub(next)/s.Every + 1) * s.Every)
+		}
+		return next, true
+	case s.Cron != "":
+		spec, err := parseCron(s.Cron)
+		if err != nil {
+			return time.Time{}, false
+		}
+		return spec.next(now)
+	}
+	return time.Time{}, false
+}
+
+// InsufficientFundsPolicy says what a scheduled transfer does when the
+// source account cannot cover it
+type InsufficientFundsPolicy int
+
+const (
+	SkipRun        InsufficientFundsPolicy = iota // skip this run, keep the schedule
+	RetryRun                                      // retry like any other failure
+	CancelSchedule                                // cancel the whole schedule
+)
+
+// ScheduledTransfer is a transfer the bank runs by itself. A run that fails
+// is retried up to MaxAttempts times in total, RetryBackoff apart, doubling
+// each time.
+type ScheduledTransfer struct {
+	ID             uint64                  `json:"id"`
+	From           int                     `json:"from"`
+	To             int                     `json:"to"`
+	Amount         int                     `json:"amount"`
+	Schedule       Schedule                `json:"schedule"`
+	MaxAttempts    int                     `json:"max_attempts,omitempty"`
+	RetryBackoff   time.Duration           `json:"retry_backoff,omitempty"`
+	OnInsufficient InsufficientFundsPolicy `json:"on_insufficient,omitempty"`
+	Next           time.Time               `json:"next"`
+}
+
+// scheduleTable holds the bank's scheduled transfers. When persistence is
+// on, every change is written to the WAL while mu is held, so the WAL and
+// the table always agree. mu is taken before the journal lock.
+type scheduleTable struct {
+	mu     sync.Mutex
+	nextID uint64
+	byID   map[uint64]*ScheduledTransfer
+	wake   chan struct{}
+}
+
+// putSchedule stores st, writing it to the WAL first. Caller holds
+// b.schedules.mu.
+func (b *Bank) putSchedule(st ScheduledTransfer) error {
+	if err := b.journal.log(walRecord{Schedule: &st}); err != nil {
+		return err
+	}
+	if b.schedules.byID == nil {
+		b.schedules.byID = make(map[uint64]*ScheduledTransfer)
+	}
+	b.schedules.byID[st.ID] = &st
+	b.schedules.nextID = max(b.schedules.nextID, st.ID)
+	return nil
+}
+
+// deleteSchedule removes schedule id, writing that to the WAL first. Caller
+// holds b.schedules.mu.
+func (b *Bank) deleteSchedule(id uint64) error {
+	if _, ok := b.schedules.byID[id]; !ok {
+		return fmt.Errorf("schedule %d: %w", id, ErrUnknownSchedule)
+	}
+	if err := b.journal.log(walRecord{Unschedule: id}); err != nil {
+		return err
+	}
+	delete(b.schedules.byID, id)
+	return nil
+}
+
+// wakeScheduler makes RunScheduler look at the schedules again
+func (b *Bank) wakeScheduler() {
+	select {
+	case b.schedules.wake <- struct{}{}:
+	default:
+	}
+}
+
+// ScheduleTransfer adds a scheduled transfer and returns its ID. The ID and
+// Next fields of st are ignored. It only runs while RunScheduler does.
+func (b *Bank) ScheduleTransfer(st ScheduledTransfer) (uint64, error) {
+	if st.Amount <= 0 {
+		return 0, fmt.Errorf("%w: %d", ErrInvalidAmount, st.Amount)
+	}
+	if err := st.Schedule.validate(); err != nil {
+		return 0, err
+	}
+	next, ok := st.Schedule.first(time.Now())
+	if !ok {
+		return 0, errors.New("schedule never runs")
+	}
+	st.Next = next
+
+	b.schedules.mu.Lock()
+	defer b.schedules.mu.Unlock()
+
+	st.ID = b.schedules.nextID + 1
+	if err := b.putSchedule(st); err != nil {
+		return 0, err
+	}
+	b.wakeScheduler()
+	return st.ID, nil
+}
+
+// CancelSchedule removes a scheduled transfer. A run already under way is
+// not interrupted.
+func (b *Bank) CancelSchedule(id uint64) error {
+	b.schedules.mu.Lock()
+	defer b.schedules.mu.Unlock()
+	return b.deleteSchedule(id)
+}
+
+// Schedules returns every scheduled transfer, ordered by ID
+func (b *Bank) Schedules() []ScheduledTransfer {
+	b.schedules.mu.Lock()
+	defer b.schedules.mu.Unlock()
+
+	list := make([]ScheduledTransfer, 0, len(b.schedules.byID))
+	for _, st := range b.schedules.byID {
+		list = append(list, *st)
+	}
+	slices.SortFunc(list, func(a, b ScheduledTransfer) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+	return list
+}
+
+// RunScheduler runs scheduled transfers as they fall due until ctx is
+// cancelled, then waits for runs under way to finish.
+//
+// Each run is recorded, advancing the schedule, before its transfer is
+// attempted. After a crash a run may be skipped, but never paid twice.
+func (b *Bank) RunScheduler(ctx context.Context) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		now := time.Now()
+		due, wait := b.dueSchedules(now)
+		for _, st := range due {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				b.runScheduled(ctx, st)
+			}()
+		}
+
+		timer.Reset(wait)
+		select {
+		case <-timer.C:
+		case <-b.schedules.wake:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// dueSchedules advances every schedule due at now and returns the runs to
+// make, and how long to wait before looking again
+func (b *Bank) dueSchedules(now time.Time) ([]ScheduledTransfer, time.Duration) {
+	b.schedules.mu.Lock()
+	defer b.schedules.mu.Unlock()
+
+	var due []ScheduledTransfer
+	wait := time.Hour
+	for _, st := range b.schedules.byID {
+		if st.Next.After(now) {
+			wait = min(wait, st.Next.Sub(now))
+			continue
+		}
+
+		run := *st
+		var err error
+		if next, ok := st.Schedule.after(st.Next, now); ok {
+			advanced := *st
+			advanced.Next = next
+			err = b.putSchedule(advanced)
+			wait = min(wait, next.Sub(now))
+		} else {
+			err = b.deleteSchedule(st.ID)
+		}
+		if err != nil {
+			// Could not record the run, so do not make it; try again soon
+			log.Printf("Schedule %d: %v\n", st.ID, err)
+			wait = min(wait, time.Second)
+			continue
+		}
+		due = append(due, run)
+	}
+	return due, wait
+}
+
+// runScheduled makes one run of st, retrying failures according to its
+// policy. A run held for review by the fraud engine is not retried.
+func (b *Bank) runScheduled(ctx context.Context, st ScheduledTransfer) {
+	backoff := st.RetryBackoff
+	for attempt := 1; ; attempt++ {
+		err := b.transfer(st.From, st.To, st.Amount)
+		if err == nil {
+			return
+		}
+
+		// Every attempt would place another hold, so one is enough
+		var held *HeldError
+		if errors.As(err, &held) {
+			log.Printf("Schedule %d: run due %v held for review as hold %d\n", st.ID, st.Next, held.HoldID)
+			return
+		}
+
+		if errors.Is(err, ErrInsufficientFunds) {
+			switch st.OnInsufficient {
+			case SkipRun:
+				log.Printf("Schedule %d: skipping run due %v: %v\n", st.ID, st.Next, err)
+				return
+			case CancelSchedule:
+				log.Printf("Schedule %d: cancelling: %v\n", st.ID, err)
+				if err := b.CancelSchedule(st.ID); err != nil && !errors.Is(err, ErrUnknownSchedule) {
+					log.Printf("Schedule %d: %v\n", st.ID, err)
+				}
+				return
+			}
+		}
+
+		if attempt >= st.MaxAttempts {
+			log.Printf("Schedule %d: giving up on run due %v after %d attempts: %v\n", st.ID, st.Next, attempt, err)
+			return
+		}
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-ctx.Done():
+			return
+		}
+	}
+}
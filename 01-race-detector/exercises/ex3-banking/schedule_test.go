@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	// A Wednesday
+	from := time.Date(2026, 10, 14, 9, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		expr string
+		want time.Time // zero if expr is invalid
+	}{
+		{"* * * * *", time.Date(2026, 10, 14, 9, 31, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2026, 10, 14, 9, 45, 0, 0, time.UTC)},
+		{"30 9 * * *", time.Date(2026, 10, 15, 9, 30, 0, 0, time.UTC)},
+		{"0 8 * * 1-5", time.Date(2026, 10, 15, 8, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)},
+		// Day of month or day of week, when both are restricted
+		{"0 0 20 * 5", time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 31 2 *", time.Time{}}, // valid, but never runs
+		{"* * * *", time.Time{}},
+		{"60 * * * *", time.Time{}},
+		{"5-1 * * * *", time.Time{}},
+		{"*/0 * * * *", time.Time{}},
+		{"a * * * *", time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			spec, err := parseCron(tt.expr)
+			if err != nil {
+				if !tt.want.IsZero() {
+					t.Fatalf("parseCron: %v", err)
+				}
+				return
+			}
+			got, ok := spec.next(from)
+			if !ok {
+				got = time.Time{}
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("next = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScheduleAfter(t *testing.T) {
+	start := time.Date(2026, 10, 14, 9, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		schedule Schedule
+		now      time.Time
+		want     time.Time
+		ok       bool
+	}{
+		{"once", Schedule{At: start}, start, time.Time{}, false},
+		{"every, on time", Schedule{Every: time.Hour}, start, start.Add(time.Hour), true},
+		{"every, missed runs catch up once", Schedule{Every: time.Hour}, start.Add(150 * time.Minute), start.Add(3 * time.Hour), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.schedule.after(start, tt.now)
+			if ok != tt.ok || !got.Equal(tt.want) {
+				t.Errorf("after = %v, %v; want %v, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestScheduleValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		schedule Schedule
+		valid    bool
+	}{
+		{"at", Schedule{At: time.Now()}, true},
+		{"every", Schedule{Every: time.Minute}, true},
+		{"cron", Schedule{Cron: "0 9 * * *"}, true},
+		{"nothing", Schedule{}, false},
+		{"two ways", Schedule{At: time.Now(), Every: time.Minute}, false},
+		{"negative interval", Schedule{Every: -time.Minute}, false},
+		{"bad cron", Schedule{Cron: "0 25 * * *"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.schedule.validate(); (err == nil) != tt.valid {
+				t.Errorf("validate = %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestRunScheduled(t *testing.T) {
+	tests := []struct {
+		name      string
+		amount    int
+		policy    InsufficientFundsPolicy
+		fraud     RuleAction
+		available int  // of the source account afterwards
+		scheduled bool // whether the schedule is still there
+	}{
+		{"paid", 100, SkipRun, Alert, 9900, true},
+		{"insufficient, skipped", 20000, SkipRun, Alert, 10000, true},
+		{"insufficient, retried", 20000, RetryRun, Alert, 10000, true},
+		{"insufficient, cancelled", 20000, CancelSchedule, Alert, 10000, false},
+		// Enough money for every attempt to place a hold, if it retried
+		{"held for review once", 600, RetryRun, HoldForReview, 9400, true},
+		{"rejected", 600, RetryRun, Reject, 10000, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBank()
+			from, _ := b.OpenAccount(10000)
+			to, _ := b.OpenAccount(0)
+			b.SetFraudEngine(NewFraudEngine(10, LargeAmountRule{Threshold: 500, Period: time.Minute, Do: tt.fraud}))
+
+			st := ScheduledTransfer{
+				From:           from,
+				To:             to,
+				Amount:         tt.amount,
+				Schedule:       Schedule{Every: time.Hour},
+				MaxAttempts:    3,
+				RetryBackoff:   time.Millisecond,
+				OnInsufficient: tt.policy,
+			}
+			id, err := b.ScheduleTransfer(st)
+			if err != nil {
+				t.Fatal(err)
+			}
+			st.ID = id
+
+			b.runScheduled(context.Background(), st)
+
+			if available, _ := b.Available(from); available != tt.available {
+				t.Errorf("available = %d, want %d", available, tt.available)
+			}
+			if got := len(b.Schedules()) == 1; got != tt.scheduled {
+				t.Errorf("still scheduled = %v, want %v", got, tt.scheduled)
+			}
+			if err := b.CancelSchedule(id); tt.scheduled == errors.Is(err, ErrUnknownSchedule) {
+				t.Errorf("CancelSchedule = %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+)
+
+// DefaultShards is the number of shards NewBank splits accounts across
+const DefaultShards = 16
+
+// shard is one partition of the bank's accounts. Account id lives in shard
+// id % len(b.shards).
+type shard struct {
+	mu       sync.RWMutex // guards accounts
+	accounts map[int]*Account
+}
+
+// NewShardedBank returns an empty bank with its accounts split across n
+// shards, each with its own lock
+func NewShardedBank(n int) *Bank {
+	b := &Bank{
+		shards:    make([]*shard, max(n, 1)),
+		schedules: scheduleTable{wake: make(chan struct{}, 1)},
+	}
+	for i := range b.shards {
+		b.shards[i] = &shard{accounts: make(map[int]*Account)}
+	}
+	b.lastID.Store(EquityAccount)
+	return b
+}
+
+// shardOf returns the shard holding account id, which must not be negative
+func (b *Bank) shardOf(id int) *shard {
+	return b.shards[id%len(b.shards)]
+}
+
+// lookup returns account id. Account IDs are never negative, but callers
+// pass IDs straight from requests, so a negative one is simply not found.
+func (b *Bank) lookup(id int) (*Account, bool) {
+	if id < 0 {
+		return nil, false
+	}
+	s := b.shardOf(id)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	account, ok := s.accounts[id]
+	return account, ok
+}
+
+// allAccounts returns every account, one shard at a time
+func (b *Bank) allAccounts() []*Account {
+	var accounts []*Account
+	for _, s := range b.shards {
+		s.mu.RLock()
+		for _, account := range s.accounts {
+			accounts = append(accounts, account)
+		}
+		s.mu.RUnlock()
+	}
+	return accounts
+}
+
+// accountIDs returns the ID of every account
+func (b *Bank) accountIDs() []int {
+	accounts := b.allAccounts()
+	ids := make([]int, len(accounts))
+	for i, account := range accounts {
+		ids[i] = account.id
+	}
+	return ids
+}
+
+// lockOrder sorts ids into the order accounts are locked in: by shard, then
+// by ID. Every caller locking more than one account uses this order, so
+// transactions over overlapping accounts cannot deadlock.
+func (b *Bank) lockOrder(ids []int) {
+	n := len(b.shards)
+	slices.SortFunc(ids, func(x, y int) int {
+		return cmp.Or(cmp.Compare(x%n, y%n), cmp.Compare(x, y))
+	})
+}
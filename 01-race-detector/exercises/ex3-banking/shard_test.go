@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestNegativeAccountIDs(t *testing.T) {
+	b := NewBank()
+	a, _ := b.OpenAccount(1000)
+
+	tests := []struct {
+		name string
+		op   func() error
+	}{
+		{"transfer to", func() error { return b.transfer(a, -1, 10) }},
+		{"transfer from", func() error { return b.transfer(-1, a, 10) }},
+		{"batch", func() error {
+			return b.TransferMany([]Posting{{AccountID: a, Amount: -10}, {AccountID: -2, Amount: 10}})
+		}},
+		{"authorize", func() error { _, err := b.Authorize(-3, 10, time.Minute); return err }},
+		{"available", func() error { _, err := b.Available(-1); return err }},
+		{"status", func() error { _, err := b.Status(-1); return err }},
+		{"statement", func() error { _, err := b.Statement(-1, time.Time{}, time.Now()); return err }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.op(); !errors.Is(err, ErrUnknownAccount) {
+				t.Errorf("error = %v, want %v", err, ErrUnknownAccount)
+			}
+		})
+	}
+
+	if got := b.GetBalance(-1); got != 0 {
+		t.Errorf("GetBalance(-1) = %d, want 0", got)
+	}
+	if b.Transfer(a, -1, 10) {
+		t.Error("Transfer to -1 succeeded")
+	}
+	if got := b.GetBalance(a); got != 1000 {
+		t.Errorf("balance = %d, want 1000", got)
+	}
+}
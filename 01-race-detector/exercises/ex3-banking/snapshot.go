@@ -0,0 +1,180 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// version is one committed balance of an account. Each account keeps a list
+// of versions from newest to oldest, so readers can find the balance as of
+// any commit still being read without taking the account lock.
+type version struct {
+	seq     uint64 // journal sequence number that produced this balance
+	balance int
+	prev    atomic.Pointer[version]
+}
+
+// setBalance commits balance as of journal entry seq. The caller holds a.mu,
+// or a is not yet visible. keep is the oldest commit a snapshot may read
+// (see commitOrder.keep), or 0 for none; older versions no snapshot can
+// need are dropped.
+func (a *Account) setBalance(seq uint64, balance int, keep uint64) {
+	a.balance = balance
+
+	v := &version{seq: seq, balance: balance}
+	v.prev.Store(a.versions.Load())
+	a.versions.Store(v)
+
+	if keep == 0 {
+		v.prev.Store(nil)
+		return
+	}
+	for n := v; n != nil; n = n.prev.Load() {
+		if n.seq <= keep {
+			n.prev.Store(nil)
+			return
+		}
+	}
+}
+
+// balanceAt returns the account's balance as of journal entry seq, and false
+// if the account did not exist yet
+func (a *Account) balanceAt(seq uint64) (int, bool) {
+	for n := a.versions.Load(); n != nil; n = n.prev.Load() {
+		if n.seq <= seq {
+			return n.balance, true
+		}
+	}
+	return 0, false
+}
+
+// Snapshot is every account balance as of a single commit point
+type Snapshot struct {
+	Seq        uint64
+	Balances   map[int]int
+	Currencies map[int]Currency
+}
+
+// Total returns the sum of every balance in the snapshot, regardless of
+// currency
+func (s Snapshot) Total() int {
+	total := 0
+	for _, balance := range s.Balances {
+		total += balance
+	}
+	return total
+}
+
+// Totals returns the sum of the balances in each currency
+func (s Snapshot) Totals() map[Currency]int {
+	totals := make(map[Currency]int)
+	for id, balance := range s.Balances {
+		totals[s.Currencies[id]] += balance
+	}
+	return totals
+}
+
+// Snapshot returns every balance as of the most recent commit whose
+// balances, and those of every commit before it, have been applied. No
+// transfer is ever seen half applied, and transfers keep committing while
+// the snapshot is read: writers are only held up while the commit point is
+// chosen, never for the scan.
+func (b *Bank) Snapshot() Snapshot {
+	commits := &b.journal.commits
+	seq := commits.register()
+	defer commits.release(seq)
+
+	// Every account committed at or before seq is already in its shard:
+	// OpenAccountIn holds the shard lock from journal append until the
+	// insert.
+	accounts := b.allAccounts()
+
+	snap := Snapshot{
+		Seq:        seq,
+		Balances:   make(map[int]int, len(accounts)),
+		Currencies: make(map[int]Currency, len(accounts)),
+	}
+	for _, account := range accounts {
+		if balance, ok := account.balanceAt(seq); ok {
+			snap.Balances[account.id] = balance
+			snap.Currencies[account.id] = account.currency
+		}
+	}
+	return snap
+}
+
+// commitOrder tracks which journal entries have had their balances applied.
+// Entries are numbered under the journal lock but applied after it is
+// released, so they can finish out of order; commitOrder lets snapshots, and
+// subscribers, see them strictly in sequence.
+type commitOrder struct {
+	mu        sync.Mutex
+	applied   uint64            // every entry up to here has been applied
+	finished  map[uint64]func() // applied entries after applied+1, with their publish
+	snapshots map[uint64]int    // seq -> readers, see Bank.Snapshot
+	oldest    uint64            // smallest key in snapshots, 0 if none
+}
+
+// keep returns the oldest commit a snapshot may read, for setBalance. A
+// snapshot taken later reads at least the current applied, so versions
+// older than the ones at keep are never needed again.
+func (c *commitOrder) keep() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.oldest != 0 {
+		return min(c.oldest, c.applied)
+	}
+	return c.applied
+}
+
+// finish records that entry seq has been applied, then publishes every
+// entry that is now applied along with all those before it, in order
+func (c *commitOrder) finish(seq uint64, publish func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.finished == nil {
+		c.finished = make(map[uint64]func())
+	}
+	c.finished[seq] = publish
+	for {
+		next, ok := c.finished[c.applied+1]
+		if !ok {
+			return
+		}
+		delete(c.finished, c.applied+1)
+		c.applied++
+		next()
+	}
+}
+
+// register marks the newest applied commit as being read and returns it
+func (c *commitOrder) register() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.snapshots == nil {
+		c.snapshots = make(map[uint64]int)
+	}
+	seq := c.applied
+	c.snapshots[seq]++
+	if c.oldest == 0 || seq < c.oldest {
+		c.oldest = seq
+	}
+	return seq
+}
+
+// release undoes register
+func (c *commitOrder) release(seq uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.snapshots[seq]--; c.snapshots[seq] > 0 {
+		return
+	}
+	delete(c.snapshots, seq)
+
+	c.oldest = 0
+	for s := range c.snapshots {
+		if c.oldest == 0 || s < c.oldest {
+			c.oldest = s
+		}
+	}
+}
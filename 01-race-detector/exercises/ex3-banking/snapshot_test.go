@@ -0,0 +1,108 @@
+package main
+
+import (
+	"maps"
+	"math/rand"
+	"slices"
+	"sync"
+	"testing"
+)
+
+func TestBalanceAt(t *testing.T) {
+	tests := []struct {
+		name string
+		keep uint64 // oldest snapshot being read when seq 5 commits
+		seq  uint64
+		want int
+		ok   bool
+	}{
+		{"newest", 0, 5, 500, true},
+		{"no readers drops older versions", 0, 4, 0, false},
+		{"between versions", 3, 4, 300, true},
+		{"older than the oldest reader", 3, 2, 0, false},
+		{"every version kept", 1, 2, 100, true},
+		{"before the account existed", 1, 0, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var a Account
+			a.setBalance(1, 100, 1)
+			a.setBalance(3, 300, 1)
+			a.setBalance(5, 500, tt.keep)
+
+			got, ok := a.balanceAt(tt.seq)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("balanceAt(%d) = %d, %v; want %d, %v", tt.seq, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestSnapshotDuringTransfers(t *testing.T) {
+	const accounts, initial = 8, 1000
+	b := NewBank()
+	var ids []int
+	for i := 0; i < accounts; i++ {
+		id, _ := b.OpenAccount(initial)
+		ids = append(ids, id)
+	}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(seed))
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				from, to := ids[rng.Intn(accounts)], ids[rng.Intn(accounts)]
+				if from != to {
+					b.transfer(from, to, rng.Intn(50)+1)
+				}
+			}
+		}(int64(w))
+	}
+
+	for i := 0; i < 200; i++ {
+		snap := b.Snapshot()
+		if total := snap.Total(); total != accounts*initial {
+			t.Errorf("snapshot at seq %d: total %d, want %d", snap.Seq, total, accounts*initial)
+		}
+		if replayed := b.journal.Replay(snap.Seq); !maps.Equal(snap.Balances, replayed) {
+			t.Errorf("snapshot at seq %d disagrees with the journal", snap.Seq)
+		}
+	}
+	close(stop)
+	wg.Wait()
+}
+
+func TestCommitOrderPublishesInSequence(t *testing.T) {
+	var c commitOrder
+	var published []uint64
+	publish := func(seq uint64) func() {
+		return func() { published = append(published, seq) }
+	}
+
+	c.finish(2, publish(2))
+	c.finish(3, publish(3))
+	if len(published) > 0 || c.register() != 0 {
+		t.Fatalf("entries after a gap were applied: %v", published)
+	}
+	c.release(0)
+
+	c.finish(1, publish(1))
+	if !slices.Equal(published, []uint64{1, 2, 3}) {
+		t.Errorf("published %v, want [1 2 3]", published)
+	}
+	if seq := c.register(); seq != 3 {
+		t.Errorf("snapshot at %d, want 3", seq)
+	}
+	if keep := c.keep(); keep != 3 {
+		t.Errorf("keep = %d, want 3", keep)
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"cmp"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrHistoryCompacted is returned for a statement period that starts no
+// later than the newest entry compacted into a snapshot
+var ErrHistoryCompacted = errors.New("statement period starts before the journal's retained history")
+
+// StatementLine is one transaction on a statement. Counterparties are the
+// accounts money came from or went to; EquityAccount for deposits and
+// interest.
+type StatementLine struct {
+	Seq            uint64    `json:"seq"`
+	Time           time.Time `json:"time"`
+	Kind           EntryKind `json:"kind"`
+	Counterparties []int     `json:"counterparties"`
+	Amount         int       `json:"amount"`
+	Balance        int       `json:"balance"` // after this transaction
+}
+
+// Statement is an account's transactions committed in [From, To)
+type Statement struct {
+	AccountID int             `json:"account_id"`
+	Currency  Currency        `json:"currency"`
+	From      time.Time       `json:"from"`
+	To        time.Time       `json:"to"`
+	Opening   int             `json:"opening_balance"`
+	Closing   int             `json:"closing_balance"`
+	Lines     []StatementLine `json:"lines"`
+}
+
+// WriteJSON writes the statement as a JSON object
+func (s *Statement) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s)
+}
+
+// WriteCSV writes the statement as CSV: an opening row, one row per
+// transaction and a closing row. Counterparties are separated by ";".
+func (s *Statement) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	row := func(seq, at, kind, counterparties string, amount, balance int) {
+		cw.Write([]string{
+			strconv.Itoa(s.AccountID), string(s.Currency), seq, at, kind,
+			counterparties, strconv.Itoa(amount), strconv.Itoa(balance),
+		})
+	}
+
+	cw.Write([]string{"account", "currency", "seq", "time", "kind", "counterparties", "amount", "balance"})
+	row("", s.From.Format(time.RFC3339Nano), "opening", "", 0, s.Opening)
+	for _, line := range s.Lines {
+		ids := make([]string, len(line.Counterparties))
+		for i, id := range line.Counterparties {
+			ids[i] = strconv.Itoa(id)
+		}
+		row(strconv.FormatUint(line.Seq, 10), line.Time.Format(time.RFC3339Nano), string(line.Kind),
+			strings.Join(ids, ";"), line.Amount, line.Balance)
+	}
+	row("", s.To.Format(time.RFC3339Nano), "closing", "", 0, s.Closing)
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// Statement returns one account's statement for transactions committed in
+// [from, to)
+func (b *Bank) Statement(id int, from, to time.Time) (*Statement, error) {
+	statements, err := b.statements(from, to, []int{id})
+	if err != nil {
+		return nil, err
+	}
+	if len(statements) == 0 {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownAccount, id)
+	}
+	return statements[0], nil
+}
+
+// Statements returns the statement of every account opened before to,
+// ordered by account ID. It works from a copy of the journal taken under a
+// read lock, and builds the statements in parallel without holding any
+// lock, so transfers keep running while it does.
+func (b *Bank) Statements(from, to time.Time) ([]*Statement, error) {
+	return b.statements(from, to, nil)
+}
+
+// statements builds the statements of ids, or of every account if ids is
+// nil
+func (b *Bank) statements(from, to time.Time, ids []int) ([]*Statement, error) {
+	// Entries up to baseSeq survive only as the opening balances in h.base,
+	// which serve any period starting after the last of them. A snapshot
+	// that does not record when that was is only trusted after the first
+	// retained entry.
+	h := b.journal.history()
+	if h.baseSeq > 0 {
+		boundary := h.baseTime
+		if boundary.IsZero() && len(h.entries) > 0 {
+			boundary = h.entries[0].Time
+		}
+		if boundary.IsZero() || !from.After(boundary) {
+			return nil, ErrHistoryCompacted
+		}
+	}
+
+	// Find each account's entries in one pass, so the workers don't each
+	// scan the whole journal
+	currencies := h.baseCur
+	opening := h.base
+	touches := make(map[int][]int) // account -> indexes into h.entries
+	for i, entry := range h.entries {
+		if !entry.Time.Before(to) {
+			break
+		}
+		for j, line := range entry.Lines {
+			if line.AccountID == EquityAccount {
+				continue
+			}
+			if entry.Kind == EntryOpen {
+				currencies[line.AccountID] = cmp.Or(line.Currency, DefaultCurrency)
+			}
+			// An account may have several lines in one entry
+			if !slices.ContainsFunc(entry.Lines[:j], func(p Posting) bool { return p.AccountID == line.AccountID }) {
+				touches[line.AccountID] = append(touches[line.AccountID], i)
+			}
+		}
+	}
+	if ids == nil {
+		for id := range currencies {
+			ids = append(ids, id)
+		}
+		slices.Sort(ids)
+	}
+
+	var statements []*Statement
+	for _, id := range ids {
+		if currency, ok := currencies[id]; ok {
+			statements = append(statements, &Statement{AccountID: id, Currency: currency, From: from, To: to})
+		}
+	}
+
+	// Each worker fills in statements from a shared queue
+	queue := make(chan *Statement)
+	var wg sync.WaitGroup
+	for range min(runtime.GOMAXPROCS(0), max(len(statements), 1)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for s := range queue {
+				s.fill(h.entries, touches[s.AccountID], opening[s.AccountID])
+			}
+		}()
+	}
+	for _, s := range statements {
+		queue <- s
+	}
+	close(queue)
+	wg.Wait()
+	return statements, nil
+}
+
+// fill computes the statement's balances and lines from the entries at
+// indexes, starting from the account's balance at the journal's base
+func (s *Statement) fill(entries []JournalEntry, indexes []int, balance int) {
+	for _, i := range indexes {
+		entry := entries[i]
+		amount := 0
+		for _, line := range entry.Lines {
+			if line.AccountID == s.AccountID {
+				amount += line.Amount
+			}
+		}
+		balance += amount
+		if entry.Time.Before(s.From) {
+			continue
+		}
+		s.Lines = append(s.Lines, StatementLine{
+			Seq:            entry.Seq,
+			Time:           entry.Time,
+			Kind:           entry.Kind,
+			Counterparties: counterparties(entry, s.AccountID, amount),
+			Amount:         amount,
+			Balance:        balance,
+		})
+	}
+
+	s.Closing = balance
+	s.Opening = balance
+	for _, line := range s.Lines {
+		s.Opening -= line.Amount
+	}
+}
+
+// counterparties returns the customer accounts on the other side of an
+// entry from id, which moved amount. Money from or to no customer account,
+// such as a deposit or interest, is against EquityAccount. An account
+// paying itself is its own counterparty.
+func counterparties(entry JournalEntry, id, amount int) []int {
+	if amount == 0 {
+		if slices.ContainsFunc(entry.Lines, func(p Posting) bool { return p.AccountID == id && p.Amount != 0 }) {
+			return []int{id}
+		}
+		return nil
+	}
+	var ids []int
+	for _, line := range entry.Lines {
+		if line.AccountID == id || line.AccountID == EquityAccount {
+			continue
+		}
+		if line.Amount != 0 && (line.Amount < 0) != (amount < 0) && !slices.Contains(ids, line.AccountID) {
+			ids = append(ids, line.AccountID)
+		}
+	}
+	if len(ids) == 0 {
+		return []int{EquityAccount}
+	}
+	return ids
+}
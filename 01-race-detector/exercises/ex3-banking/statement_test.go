@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStatementAfterCompaction(t *testing.T) {
+	dir := t.TempDir()
+	b, err := OpenBank(dir, PersistOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := time.Now()
+	from, _ := b.OpenAccount(1000)
+	to, _ := b.OpenAccount(0)
+	if err := b.transfer(from, to, 100); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err = OpenBank(dir, PersistOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	time.Sleep(time.Millisecond)
+	compacted := time.Now()
+	time.Sleep(time.Millisecond)
+	if err := b.transfer(from, to, 50); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name             string
+		from             time.Time
+		wantErr          error
+		opening, closing int
+		lines            int
+	}{
+		{"starts in compacted history", before, ErrHistoryCompacted, 0, 0, 0},
+		{"starts after compaction", compacted, nil, 900, 850, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := b.Statement(from, tt.from, time.Now().Add(time.Second))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if s.Opening != tt.opening || s.Closing != tt.closing || len(s.Lines) != tt.lines {
+				t.Errorf("opening %d, closing %d, %d lines; want %d, %d, %d",
+					s.Opening, s.Closing, len(s.Lines), tt.opening, tt.closing, tt.lines)
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+)
+
+// StressConfig sizes a stress run. Each worker draws its operations from its
+// own generator seeded from Seed, so a seed always produces the same
+// operations per worker; only their interleaving varies. Accounts are picked
+// by ID from every ID the run's creators will open, not from those opened so
+// far, so an operation on an account not open yet fails instead of changing
+// what is drawn.
+type StressConfig struct {
+	Seed         int64
+	Creators     int // goroutines opening accounts
+	Transferrers int // goroutines moving money
+	Readers      int // goroutines checking invariants
+	Ops          int // operations per goroutine
+}
+
+// stressOp is one completed operation in the stress log
+type stressOp struct {
+	N      int64
+	Worker string
+	Op     string
+	Args   []int
+	Err    error
+	Seq    uint64 // journal entry the operation committed, or read as of; 0 if neither
+}
+
+// stressRun is the shared state of one stress run
+type stressRun struct {
+	cfg  StressConfig
+	bank *Bank
+
+	mu  sync.Mutex
+	ops []stressOp
+
+	n         atomic.Int64
+	deposited atomic.Int64
+	stop      chan struct{}
+	failOnce  sync.Once
+	failure   error
+}
+
+// runStress runs cfg against a fresh bank. It checks continuously that money
+// is conserved and no balance goes negative, and on the first violation
+// writes the full operation log to w and returns the violation.
+func runStress(cfg StressConfig, w io.Writer) error {
+	return newStressRun(cfg).run(w)
+}
+
+// newStressRun prepares cfg to run against a fresh bank
+func newStressRun(cfg StressConfig) *stressRun {
+	return &stressRun{cfg: cfg, bank: NewBank(), stop: make(chan struct{})}
+}
+
+// run is runStress
+func (r *stressRun) run(w io.Writer) error {
+	cfg := r.cfg
+	fmt.Fprintf(w, "Stress: seed %d, %d creators, %d transferrers, %d readers, %d ops each\n",
+		cfg.Seed, cfg.Creators, cfg.Transferrers, cfg.Readers, cfg.Ops)
+
+	var wg sync.WaitGroup
+	start := func(kind string, count int, offset int64, fn func(name string, rng *rand.Rand)) {
+		for i := 0; i < count; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				fn(fmt.Sprintf("%s-%d", kind, i), rand.New(rand.NewSource(cfg.Seed+offset+int64(i))))
+			}()
+		}
+	}
+	start("creator", cfg.Creators, 0, r.creator)
+	start("transferrer", cfg.Transferrers, 1000, r.transferrer)
+	start("reader", cfg.Readers, 2000, r.reader)
+	wg.Wait()
+
+	if r.failure == nil {
+		r.check("final")
+		if total, want := r.bank.TotalBalance(), int(r.deposited.Load()); total != want {
+			r.fail(fmt.Errorf("final: total %d, deposited %d", total, want))
+		}
+		if diffs, err := r.bank.Reconcile(); err != nil {
+			r.fail(fmt.Errorf("final: %w", err))
+		} else if len(diffs) > 0 {
+			r.fail(fmt.Errorf("final: %d accounts disagree with the journal, first %+v", len(diffs), diffs[0]))
+		}
+	}
+
+	if r.failure != nil {
+		fmt.Fprintf(w, "INVARIANT VIOLATED: %v\nOperations, in completion order:\n", r.failure)
+		r.mu.Lock()
+		for _, op := range r.ops {
+			fmt.Fprintf(w, "%6d seq=%-6d %-14s %-8s %v err=%v\n", op.N, op.Seq, op.Worker, op.Op, op.Args, op.Err)
+		}
+		r.mu.Unlock()
+		return r.failure
+	}
+	fmt.Fprintf(w, "Stress: %d operations, %d accounts, total %d conserved\n",
+		r.n.Load(), len(r.bank.allAccounts()), r.deposited.Load())
+	return nil
+}
+
+// record appends a completed operation to the log. seq is the entry it
+// committed, or the sequence number it read as of.
+func (r *stressRun) record(worker, op string, seq uint64, err error, args ...int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ops = append(r.ops, stressOp{N: r.n.Add(1), Worker: worker, Op: op, Args: args, Err: err, Seq: seq})
+}
+
+// fail records the first violation and stops every worker
+func (r *stressRun) fail(err error) {
+	r.failOnce.Do(func() {
+		r.failure = err
+		close(r.stop)
+	})
+}
+
+// stopped reports whether a violation has stopped the run
+func (r *stressRun) stopped() bool {
+	select {
+	case <-r.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// pick draws an account ID the run's creators will open. Account IDs are
+// handed out from 1 upwards.
+func (r *stressRun) pick(rng *rand.Rand) int {
+	return rng.Intn(max(r.cfg.Creators*r.cfg.Ops, 1)) + 1
+}
+
+func (r *stressRun) creator(name string, rng *rand.Rand) {
+	for i := 0; i < r.cfg.Ops && !r.stopped(); i++ {
+		deposit := rng.Intn(1000)
+		r.deposited.Add(int64(deposit))
+		id, seq, err := r.bank.openAccount(DefaultCurrency, deposit)
+		if err != nil {
+			r.deposited.Add(-int64(deposit))
+		}
+		r.record(name, "open", seq, err, id, deposit)
+	}
+}
+
+func (r *stressRun) transferrer(name string, rng *rand.Rand) {
+	for i := 0; i < r.cfg.Ops && !r.stopped(); i++ {
+		from, to, amount := r.pick(rng), r.pick(rng), rng.Intn(200)+1
+		if rng.Intn(5) == 0 {
+			// Split a payment across two accounts
+			other := r.pick(rng)
+			seq, err := r.bank.transferMany([]Posting{
+				{AccountID: from, Amount: -2 * amount},
+				{AccountID: to, Amount: amount},
+				{AccountID: other, Amount: amount},
+			}, nil)
+			r.record(name, "split", seq, err, from, to, other, amount)
+			continue
+		}
+		seq, err := r.bank.transferKeyed(from, to, amount, nil)
+		r.record(name, "transfer", seq, err, from, to, amount)
+	}
+}
+
+func (r *stressRun) reader(name string, rng *rand.Rand) {
+	for i := 0; i < r.cfg.Ops && !r.stopped(); i++ {
+		if rng.Intn(2) == 0 {
+			r.check(name)
+			continue
+		}
+		id := r.pick(rng)
+		balance := r.bank.GetBalance(id)
+		r.record(name, "balance", 0, nil, id, balance)
+		if balance < 0 {
+			r.fail(fmt.Errorf("%s: account %d has negative balance %d", name, id, balance))
+		}
+	}
+}
+
+// check takes a snapshot and verifies it against the journal replayed to the
+// same sequence number: every stored balance in the snapshot matches the
+// account's journal balance, the snapshot holds exactly the accounts the
+// journal had opened, its total in each currency equals the deposits, and
+// no balance is negative
+func (r *stressRun) check(name string) {
+	snap := r.bank.Snapshot()
+	replayed := r.bank.journal.Replay(snap.Seq)
+	equity := r.bank.journal.Equity(snap.Seq)
+	r.record(name, "snapshot", snap.Seq, nil, snap.Total())
+
+	for id, want := range replayed {
+		if balance, ok := snap.Balances[id]; !ok || balance != want {
+			r.fail(fmt.Errorf("%s: snapshot at seq %d has account %d at %d (present %v), journal %d",
+				name, snap.Seq, id, balance, ok, want))
+		}
+	}
+	for id, balance := range snap.Balances {
+		if _, ok := replayed[id]; !ok {
+			r.fail(fmt.Errorf("%s: snapshot at seq %d has account %d, not opened in the journal", name, snap.Seq, id))
+		}
+		if balance < 0 {
+			r.fail(fmt.Errorf("%s: snapshot at seq %d has account %d at %d", name, snap.Seq, id, balance))
+		}
+	}
+	for c, total := range snap.Totals() {
+		if want := -equity[c]; total != want {
+			r.fail(fmt.Errorf("%s: snapshot at seq %d totals %d %s, deposits %d", name, snap.Seq, total, c, want))
+		}
+	}
+}
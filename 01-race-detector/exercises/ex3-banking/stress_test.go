@@ -0,0 +1,71 @@
+package main
+
+import (
+	"cmp"
+	"io"
+	"slices"
+	"testing"
+)
+
+func TestStressLogReplays(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  StressConfig
+	}{
+		{"one of each", StressConfig{Seed: 1, Creators: 1, Transferrers: 1, Readers: 1, Ops: 200}},
+		{"contended", StressConfig{Seed: 7, Creators: 2, Transferrers: 8, Readers: 2, Ops: 200}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newStressRun(tt.cfg)
+			if err := r.run(io.Discard); err != nil {
+				t.Fatal(err)
+			}
+
+			// Replay every committed operation in sequence order on a fresh
+			// bank. Account IDs are handed out before the opening entry is
+			// numbered, so they are mapped rather than assumed to match.
+			var committed []stressOp
+			for _, op := range r.ops {
+				if op.Err == nil && op.Op != "balance" && op.Op != "snapshot" {
+					committed = append(committed, op)
+				}
+			}
+			slices.SortFunc(committed, func(a, b stressOp) int { return cmp.Compare(a.Seq, b.Seq) })
+
+			replay := NewBank()
+			ids := make(map[int]int)
+			for i, op := range committed {
+				if want := uint64(i + 1); op.Seq != want {
+					t.Fatalf("operation %d logged seq %d, want %d", op.N, op.Seq, want)
+				}
+				var err error
+				switch op.Op {
+				case "open":
+					ids[op.Args[0]], err = replay.OpenAccount(op.Args[1])
+				case "transfer":
+					err = replay.transfer(ids[op.Args[0]], ids[op.Args[1]], op.Args[2])
+				case "split":
+					amount := op.Args[3]
+					err = replay.TransferMany([]Posting{
+						{AccountID: ids[op.Args[0]], Amount: -2 * amount},
+						{AccountID: ids[op.Args[1]], Amount: amount},
+						{AccountID: ids[op.Args[2]], Amount: amount},
+					})
+				}
+				if err != nil {
+					t.Fatalf("replaying %s %v at seq %d: %v", op.Op, op.Args, op.Seq, err)
+				}
+			}
+
+			if got, want := replay.journal.LastSeq(), r.bank.journal.LastSeq(); got != want {
+				t.Errorf("replay reached seq %d, want %d", got, want)
+			}
+			for id, replayed := range ids {
+				if got, want := replay.GetBalance(replayed), r.bank.GetBalance(id); got != want {
+					t.Errorf("account %d: replayed balance %d, want %d", id, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ErrInvalidBuffer is returned by Subscribe for a buffer that cannot hold an
+// event
+var ErrInvalidBuffer = errors.New("subscription buffer must hold at least one event")
+
+// EventKind says what an Event reports
+type EventKind string
+
+const (
+	// EventTransaction is a committed journal entry
+	EventTransaction EventKind = "transaction"
+	// EventBalance is an account's balance after a committed entry
+	EventBalance EventKind = "balance"
+	// EventGap says Missed events were dropped because the subscriber fell
+	// behind. It is delivered before the next event that fits.
+	EventGap EventKind = "gap"
+)
+
+// Event is one item in a subscription. Seq is the journal sequence number
+// of the entry it reports; for a gap, the first entry missed. Entry is the
+// subscriber's own copy, so changing it does not change the journal.
+type Event struct {
+	Seq       uint64
+	Time      time.Time
+	Kind      EventKind
+	Entry     *JournalEntry // EventTransaction
+	AccountID int           // EventBalance
+	Balance   int           // EventBalance
+	Currency  Currency      // EventBalance
+	Missed    int           // EventGap
+}
+
+// EventFilter selects the events a subscriber receives. Empty fields match
+// everything.
+type EventFilter struct {
+	Accounts []int       // only entries touching, and balances of, these accounts
+	Kinds    []EventKind // only these kinds; gaps are always delivered
+}
+
+// SlowPolicy says what happens when a subscriber's buffer is full
+type SlowPolicy int
+
+const (
+	// DropWithGap drops events and later delivers an EventGap counting them
+	DropWithGap SlowPolicy = iota
+	// Disconnect closes the subscription
+	Disconnect
+)
+
+// Subscription is a live stream of committed events. C is closed by
+// Unsubscribe, or when a Disconnect subscriber falls behind.
+type Subscription struct {
+	C <-chan Event
+
+	bank   *Bank
+	c      chan Event
+	filter EventFilter
+	policy SlowPolicy
+
+	// Guarded by the bank's subscriber lock
+	closed   bool
+	missed   int
+	gapStart uint64
+}
+
+// subscribers is the bank's set of subscriptions. Its lock is taken while
+// holding the journal's commit order lock, never the other way round.
+type subscribers struct {
+	mu   sync.Mutex
+	subs []*Subscription
+}
+
+// Subscribe returns a stream of every event matching filter committed from
+// now on, in sequence order, buffered up to buffer events. Publishing never
+// waits for a subscriber, so buffer must be at least 1.
+func (b *Bank) Subscribe(filter EventFilter, buffer int, policy SlowPolicy) (*Subscription, error) {
+	if buffer < 1 {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidBuffer, buffer)
+	}
+	c := make(chan Event, buffer)
+	sub := &Subscription{C: c, bank: b, c: c, filter: filter, policy: policy}
+
+	b.subscribers.mu.Lock()
+	defer b.subscribers.mu.Unlock()
+	b.subscribers.subs = append(b.subscribers.subs, sub)
+	return sub, nil
+}
+
+// Unsubscribe stops the subscription and closes C
+func (s *Subscription) Unsubscribe() {
+	subs := &s.bank.subscribers
+	subs.mu.Lock()
+	defer subs.mu.Unlock()
+	subs.remove(s)
+}
+
+// remove drops s and closes its channel. Caller holds subs.mu.
+func (subs *subscribers) remove(s *Subscription) {
+	if s.closed {
+		return
+	}
+	s.closed = true
+	close(s.c)
+	subs.subs = slices.DeleteFunc(subs.subs, func(other *Subscription) bool {
+		return other == s
+	})
+}
+
+// matches reports whether the filter selects an event of kind about
+// accounts
+func (f EventFilter) matches(kind EventKind, accounts ...int) bool {
+	if len(f.Kinds) > 0 && !slices.Contains(f.Kinds, kind) {
+		return false
+	}
+	if len(f.Accounts) == 0 {
+		return true
+	}
+	for _, id := range accounts {
+		if slices.Contains(f.Accounts, id) {
+			return true
+		}
+	}
+	return false
+}
+
+// publication captures a committed entry, and the new balances of accounts,
+// and returns a function delivering them to every subscriber. Caller holds
+// the locks of accounts; the journal calls the function in sequence order
+// (see Journal.append).
+func (b *Bank) publication(entry JournalEntry, accounts []*Account) func() {
+	balances := make([]Event, len(accounts))
+	for i, a := range accounts {
+		balances[i] = Event{
+			Seq:       entry.Seq,
+			Time:      entry.Time,
+			Kind:      EventBalance,
+			AccountID: a.id,
+			Balance:   a.balance,
+			Currency:  a.currency,
+		}
+	}
+	return func() { b.publish(entry, balances) }
+}
+
+// publish delivers a committed entry, and the balance events captured with
+// it, to every subscriber. It never blocks.
+func (b *Bank) publish(entry JournalEntry, balances []Event) {
+	subs := &b.subscribers
+	subs.mu.Lock()
+	defer subs.mu.Unlock()
+	if len(subs.subs) == 0 {
+		return
+	}
+
+	touched := make([]int, 0, len(entry.Lines))
+	for _, line := range entry.Lines {
+		touched = append(touched, line.AccountID)
+	}
+
+	for _, s := range slices.Clone(subs.subs) {
+		if s.filter.matches(EventTransaction, touched...) {
+			e := entry.clone()
+			subs.send(s, Event{Seq: entry.Seq, Time: entry.Time, Kind: EventTransaction, Entry: &e})
+		}
+		for _, e := range balances {
+			if s.filter.matches(EventBalance, e.AccountID) {
+				subs.send(s, e)
+			}
+		}
+	}
+}
+
+// send delivers e to s without blocking, applying s's policy if its buffer
+// is full. Caller holds subs.mu.
+func (subs *subscribers) send(s *Subscription, e Event) {
+	if s.closed {
+		return
+	}
+
+	// Owed a gap marker: it has to go first
+	if s.missed > 0 {
+		gap := Event{Seq: s.gapStart, Time: e.Time, Kind: EventGap, Missed: s.missed}
+		select {
+		case s.c <- gap:
+			s.missed = 0
+		default:
+			s.missed++
+			return
+		}
+	}
+
+	select {
+	case s.c <- e:
+		return
+	default:
+	}
+
+	switch s.policy {
+	case Disconnect:
+		subs.remove(s)
+	default:
+		if s.missed == 0 {
+			s.gapStart = e.Seq
+		}
+		s.missed++
+	}
+}
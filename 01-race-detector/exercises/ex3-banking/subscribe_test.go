@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestSubscribeRejectsBadBuffer(t *testing.T) {
+	tests := []struct {
+		buffer  int
+		wantErr bool
+	}{
+		{-1, true},
+		{0, true},
+		{1, false},
+	}
+	for _, tt := range tests {
+		sub, err := NewBank().Subscribe(EventFilter{}, tt.buffer, DropWithGap)
+		if got := errors.Is(err, ErrInvalidBuffer); got != tt.wantErr {
+			t.Errorf("Subscribe(buffer %d) error = %v, want ErrInvalidBuffer %v", tt.buffer, err, tt.wantErr)
+		}
+		if sub != nil {
+			sub.Unsubscribe()
+		}
+	}
+}
+
+func TestSubscribeSlowPolicy(t *testing.T) {
+	// want is everything the subscriber reads after the first transfers,
+	// then after one more transfer once it has caught up. "closed" stands
+	// for the channel being closed.
+	type read struct {
+		kind   EventKind
+		missed int
+	}
+	tests := []struct {
+		name      string
+		buffer    int
+		policy    SlowPolicy
+		transfers int
+		want      []read
+	}{
+		{
+			name: "keeps up", buffer: 4, policy: DropWithGap, transfers: 2,
+			want: []read{{EventTransaction, 0}, {EventTransaction, 0}, {EventTransaction, 0}},
+		},
+		{
+			name: "gap", buffer: 2, policy: DropWithGap, transfers: 5,
+			want: []read{{EventTransaction, 0}, {EventTransaction, 0}, {EventGap, 3}, {EventTransaction, 0}},
+		},
+		{
+			name: "disconnect", buffer: 1, policy: Disconnect, transfers: 2,
+			want: []read{{EventTransaction, 0}, {"closed", 0}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBank()
+			from, _ := b.OpenAccount(100)
+			to, _ := b.OpenAccount(0)
+			sub, err := b.Subscribe(EventFilter{Kinds: []EventKind{EventTransaction}}, tt.buffer, tt.policy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer sub.Unsubscribe()
+
+			for range tt.transfers {
+				if err := b.transfer(from, to, 1); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var got []read
+			drain := func() {
+				for len(sub.C) > 0 {
+					e := <-sub.C
+					got = append(got, read{e.Kind, e.Missed})
+				}
+			}
+			drain()
+			if err := b.transfer(from, to, 1); err != nil {
+				t.Fatal(err)
+			}
+			drain()
+			select {
+			case _, ok := <-sub.C:
+				if !ok {
+					got = append(got, read{"closed", 0})
+				}
+			default:
+			}
+
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("read %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubscriberCannotChangeHistory(t *testing.T) {
+	b := NewBank()
+	from, _ := b.OpenAccount(100)
+	to, _ := b.OpenAccount(0)
+	sub, err := b.Subscribe(EventFilter{Kinds: []EventKind{EventTransaction}}, 1, DropWithGap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Unsubscribe()
+
+	if err := b.transfer(from, to, 10); err != nil {
+		t.Fatal(err)
+	}
+	e := <-sub.C
+	e.Entry.Lines[0].Amount = 1_000_000
+
+	entries := b.journal.Entries()
+	if got := entries[len(entries)-1].Lines[0].Amount; got != -10 {
+		t.Errorf("journal line amount = %d after subscriber changed its copy, want -10", got)
+	}
+}
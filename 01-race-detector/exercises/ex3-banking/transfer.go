@@ -0,0 +1,194 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+var (
+	ErrUnknownAccount    = errors.New("unknown account")
+	ErrInsufficientFunds = errors.New("insufficient funds")
+	ErrUnbalanced        = errors.New("postings do not balance")
+	ErrEmptyTransfer     = errors.New("transfer has no postings")
+)
+
+// Posting is one leg of a multi-account transaction. A positive Amount
+// credits the account, a negative Amount debits it. Amount is in minor units
+// of the account's currency; Currency may be left empty and is filled in
+// when the posting is committed.
+type Posting struct {
+	AccountID int
+	Amount    int
+	Currency  Currency `json:",omitempty"`
+}
+
+// TransferMany applies a batch of debits and credits as a single atomic
+// transaction: either every posting is applied or none is. There must be at
+// least one posting, and the postings must sum to zero in each currency so that no money is created or destroyed, and
+// no account may be debited past its available balance (see Available).
+// Use Transfer to move money between currencies.
+//
+// Accounts are locked in a fixed order (see lockOrder), so concurrent
+// transactions over overlapping accounts can never deadlock.
+func (b *Bank) TransferMany(postings []Posting) error {
+	_, err := b.transferMany(postings, nil)
+	return err
+}
+
+// transferMany is TransferMany, recording key, if any, in the entry. It
+// returns the committed entry's sequence number.
+func (b *Bank) transferMany(postings []Posting, key *IdempotencyKey) (uint64, error) {
+	if len(postings) == 0 {
+		return 0, ErrEmptyTransfer
+	}
+	ids := make([]int, 0, len(postings))
+	for _, p := range postings {
+		ids = append(ids, p.AccountID)
+	}
+
+	accounts, err := b.lockAccounts(ids)
+	if err != nil {
+		return 0, err
+	}
+	defer unlockAccounts(accounts)
+	return b.post(JournalEntry{Kind: EntryTransfer, Lines: postings, Key: key}, accounts, true)
+}
+
+// transfer moves amount, in the currency of fromID, to toID. Between
+// accounts in different currencies the amount is converted at the current
+// FX rate, through EquityAccount, and the rate is recorded in the entry.
+func (b *Bank) transfer(fromID, toID, amount int) error {
+	_, err := b.transferKeyed(fromID, toID, amount, nil)
+	return err
+}
+
+// transferKeyed is transfer, recording key, if any, in the entry. It returns
+// the committed entry's sequence number.
+func (b *Bank) transferKeyed(fromID, toID, amount int, key *IdempotencyKey) (uint64, error) {
+	accounts, err := b.lockAccounts([]int{fromID, toID})
+	if err != nil {
+		return 0, err
+	}
+	defer unlockAccounts(accounts)
+
+	from, to := accounts[0], accounts[len(accounts)-1]
+	if from.id != fromID {
+		from, to = to, from
+	}
+
+	entry := JournalEntry{Kind: EntryTransfer, Key: key, Lines: []Posting{
+		{AccountID: fromID, Amount: -amount},
+		{AccountID: toID, Amount: amount},
+	}}
+	if from.currency != to.currency {
+		quote, err := b.fx.Quote(from.currency, to.currency)
+		if err != nil {
+			return 0, err
+		}
+		converted, err := quote.Convert(amount)
+		if err != nil {
+			return 0, err
+		}
+		entry.FX = &quote
+		entry.Lines = []Posting{
+			{AccountID: fromID, Amount: -amount},
+			{AccountID: EquityAccount, Amount: amount, Currency: from.currency},
+			{AccountID: EquityAccount, Amount: -converted, Currency: to.currency},
+			{AccountID: toID, Amount: converted},
+		}
+	}
+
+	return b.post(entry, accounts, true)
+}
+
+// post checks and commits entry, returning its sequence number. Caller holds
+// the locks of accounts, which must cover every line except those for
+// EquityAccount; those must carry their currency. If screened, the entry
+// must also pass the fraud engine, if any, once every other check has
+// passed.
+func (b *Bank) post(entry JournalEntry, accounts []*Account, screened bool) (uint64, error) {
+	byID := make(map[int]*Account, len(accounts))
+	for _, a := range accounts {
+		byID[a.id] = a
+	}
+
+	// Check everything before changing anything
+	entry.Lines = slices.Clone(entry.Lines)
+	net := make(map[int]int, len(accounts))
+	sums := make(map[Currency]int)
+	for i, p := range entry.Lines {
+		if p.AccountID != EquityAccount {
+			if err := byID[p.AccountID].checkActive(); err != nil {
+				return 0, err
+			}
+			c := byID[p.AccountID].currency
+			if p.Currency != "" && p.Currency != c {
+				return 0, fmt.Errorf("account %d: %w: %s is not %s", p.AccountID, ErrCurrencyMismatch, p.Currency, c)
+			}
+			entry.Lines[i].Currency = c
+			net[p.AccountID] += p.Amount
+		}
+		sums[entry.Lines[i].Currency] += p.Amount
+	}
+	for c, sum := range sums {
+		if sum != 0 {
+			return 0, fmt.Errorf("%w: net %d %s", ErrUnbalanced, sum, c)
+		}
+	}
+	for id, change := range net {
+		if change < 0 && byID[id].available()+change < 0 {
+			return 0, fmt.Errorf("account %d: %w", id, ErrInsufficientFunds)
+		}
+	}
+
+	commit := func() {}
+	if screened {
+		var err error
+		if commit, err = b.screen(entry, byID); err != nil {
+			return 0, err
+		}
+	}
+
+	committed, err := b.journal.append(entry, func(entry JournalEntry, keep uint64) func() {
+		for id, change := range net {
+			byID[id].setBalance(entry.Seq, byID[id].balance+change, keep)
+		}
+		return b.publication(entry, accounts)
+	})
+	if err != nil {
+		return 0, err
+	}
+	commit()
+	return committed.Seq, nil
+}
+
+// lockAccounts looks up every account in ids and locks them in lockOrder.
+// Duplicate IDs are locked once. The accounts are returned in lock order;
+// release them with unlockAccounts.
+func (b *Bank) lockAccounts(ids []int) ([]*Account, error) {
+	ids = slices.Clone(ids)
+	b.lockOrder(ids)
+	ids = slices.Compact(ids)
+
+	accounts := make([]*Account, 0, len(ids))
+	for _, id := range ids {
+		account, ok := b.lookup(id)
+		if !ok {
+			return nil, fmt.Errorf("account %d: %w", id, ErrUnknownAccount)
+		}
+		accounts = append(accounts, account)
+	}
+
+	for _, account := range accounts {
+		account.mu.Lock()
+	}
+	return accounts, nil
+}
+
+// unlockAccounts releases accounts locked by lockAccounts
+func unlockAccounts(accounts []*Account) {
+	for i := len(accounts) - 1; i >= 0; i-- {
+		accounts[i].mu.Unlock()
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTransferMany(t *testing.T) {
+	tests := []struct {
+		name     string
+		postings func(a, b, c int) []Posting
+		keyed    bool
+		wantErr  error
+		want     [3]int
+	}{
+		{
+			name: "balanced",
+			postings: func(a, b, c int) []Posting {
+				return []Posting{{AccountID: a, Amount: -300}, {AccountID: b, Amount: 100}, {AccountID: c, Amount: 200}}
+			},
+			want: [3]int{700, 100, 200},
+		},
+		{
+			name: "unbalanced",
+			postings: func(a, b, c int) []Posting {
+				return []Posting{{AccountID: a, Amount: -300}, {AccountID: b, Amount: 100}, {AccountID: c, Amount: 100}}
+			},
+			wantErr: ErrUnbalanced,
+			want:    [3]int{1000, 0, 0},
+		},
+		{
+			name: "one debit short of funds",
+			postings: func(a, b, c int) []Posting {
+				return []Posting{{AccountID: a, Amount: -300}, {AccountID: b, Amount: -50}, {AccountID: c, Amount: 350}}
+			},
+			wantErr: ErrInsufficientFunds,
+			want:    [3]int{1000, 0, 0},
+		},
+		{
+			name:     "empty",
+			postings: func(a, b, c int) []Posting { return []Posting{} },
+			wantErr:  ErrEmptyTransfer,
+			want:     [3]int{1000, 0, 0},
+		},
+		{
+			name:     "nil",
+			postings: func(a, b, c int) []Posting { return nil },
+			wantErr:  ErrEmptyTransfer,
+			want:     [3]int{1000, 0, 0},
+		},
+		{
+			name:     "empty with a key",
+			postings: func(a, b, c int) []Posting { return nil },
+			keyed:    true,
+			wantErr:  ErrEmptyTransfer,
+			want:     [3]int{1000, 0, 0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBank()
+			x, _ := b.OpenAccount(1000)
+			y, _ := b.OpenAccount(0)
+			z, _ := b.OpenAccount(0)
+			seq := b.journal.LastSeq()
+
+			var err error
+			if tt.keyed {
+				err = b.TransferManyWithKey("k", tt.postings(x, y, z))
+			} else {
+				err = b.TransferMany(tt.postings(x, y, z))
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+
+			got := [3]int{b.GetBalance(x), b.GetBalance(y), b.GetBalance(z)}
+			if got != tt.want {
+				t.Errorf("balances = %v, want %v", got, tt.want)
+			}
+			wantSeq := seq
+			if tt.wantErr == nil {
+				wantSeq++
+			}
+			if last := b.journal.LastSeq(); last != wantSeq {
+				t.Errorf("journal at seq %d, want %d", last, wantSeq)
+			}
+		})
+	}
+}
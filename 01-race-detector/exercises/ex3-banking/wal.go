@@ -0,0 +1,509 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	walFile      = "wal.log"
+	snapshotFile = "snapshot.json"
+
+	// Every WAL record is an 8 byte header followed by a JSON payload. The
+	// header holds the payload length and its CRC-32C, both little endian.
+	walHeaderSize = 8
+)
+
+var (
+	ErrCorruptWAL = errors.New("corrupt WAL")
+
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// walRecord is the payload of one WAL record. Exactly one field is set.
+type walRecord struct {
+	Entry      *JournalEntry      `json:"entry,omitempty"`
+	Schedule   *ScheduledTransfer `json:"schedule,omitempty"`   // added or advanced
+	Unschedule uint64             `json:"unschedule,omitempty"` // schedule ID removed
+	Hold       *holdRecord        `json:"hold,omitempty"`       // placed
+	Release    uint64             `json:"release,omitempty"`    // hold ID released or expired
+	Overdraft  *overdraftRecord   `json:"overdraft,omitempty"`  // limit set
+}
+
+// WAL is an append-only, checksummed log of committed journal entries and
+// scheduled transfers
+type WAL struct {
+	path string
+	f    *os.File
+}
+
+// openWAL opens path for appending, creating it if needed
+func openWAL(path string) (*WAL, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &WAL{path: path, f: f}, nil
+}
+
+// encodeRecord frames rec with its length and checksum
+func encodeRecord(rec walRecord) ([]byte, error) {
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, walHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(buf[4:8], crc32.Checksum(payload, crcTable))
+	copy(buf[walHeaderSize:], payload)
+	return buf, nil
+}
+
+// Append writes rec and fsyncs it before returning
+func (w *WAL) Append(rec walRecord) error {
+	buf, err := encodeRecord(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := w.f.Write(buf); err != nil {
+		return fmt.Errorf("wal append: %w", err)
+	}
+	if err := w.f.Sync(); err != nil {
+		return fmt.Errorf("wal sync: %w", err)
+	}
+	return nil
+}
+
+// Close closes the log file
+func (w *WAL) Close() error {
+	return w.f.Close()
+}
+
+// readWAL returns every intact record in path. A torn final record, left by
+// a crash part way through a write, is truncated away. Damage anywhere
+// before the final record is reported as ErrCorruptWAL: a record whose
+// length runs past the end of the file is only torn if no intact record
+// follows it.
+func readWAL(path string) ([]walRecord, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := info.Size()
+
+	var records []walRecord
+	var offset int64
+	r := bufio.NewReader(f)
+	header := make([]byte, walHeaderSize)
+	for offset < size {
+		torn := func(reason string) ([]walRecord, error) {
+			log.Printf("WAL: truncating torn record at offset %d (%s)\n", offset, reason)
+			if err := f.Truncate(offset); err != nil {
+				return nil, err
+			}
+			return records, f.Sync()
+		}
+
+		if _, err := io.ReadFull(r, header); err != nil {
+			return torn("short header")
+		}
+		length := int64(binary.LittleEndian.Uint32(header[0:4]))
+		sum := binary.LittleEndian.Uint32(header[4:8])
+		end := offset + walHeaderSize + length
+		if end > size {
+			intact, err := intactRecordAfter(f, offset+1, size)
+			if err != nil {
+				return nil, err
+			}
+			if intact {
+				return nil, fmt.Errorf("%w: bad length at offset %d", ErrCorruptWAL, offset)
+			}
+			return torn("short payload")
+		}
+
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return nil, err
+		}
+
+		var rec walRecord
+		if crc32.Checksum(payload, crcTable) != sum || json.Unmarshal(payload, &rec) != nil {
+			if end == size {
+				return torn("bad checksum")
+			}
+			return nil, fmt.Errorf("%w: bad record at offset %d", ErrCorruptWAL, offset)
+		}
+
+		records = append(records, rec)
+		offset = end
+	}
+	return records, nil
+}
+
+// intactRecordAfter reports whether a checksummed record starts anywhere in
+// f between from and size. Only a crash mid-write can damage the last
+// record, so finding one means the log is corrupt, not torn.
+func intactRecordAfter(f *os.File, from, size int64) (bool, error) {
+	if from >= size {
+		return false, nil
+	}
+	tail := make([]byte, size-from)
+	if _, err := f.ReadAt(tail, from); err != nil {
+		return false, err
+	}
+	for i := 0; i+walHeaderSize < len(tail); i++ {
+		length := int(binary.LittleEndian.Uint32(tail[i : i+4]))
+		start, end := i+walHeaderSize, i+walHeaderSize+length
+		// Every payload is a JSON object
+		if length == 0 || end > len(tail) || tail[start] != '{' {
+			continue
+		}
+		if crc32.Checksum(tail[start:end], crcTable) == binary.LittleEndian.Uint32(tail[i+4:i+8]) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// snapshot is the on-disk checkpoint of every balance as of Seq
+type snapshot struct {
+	Seq        uint64                `json:"seq"`
+	Time       time.Time             `json:"time"` // commit time of entry Seq
+	Balances   map[int]int           `json:"balances"`
+	Currencies map[int]Currency      `json:"currencies,omitempty"`
+	Accrued    map[int]string        `json:"accrued,omitempty"`
+	Statuses   map[int]AccountStatus `json:"statuses,omitempty"`
+	Keys       []keyRecord           `json:"keys,omitempty"` // idempotency keys still retained
+	Holds      []holdRecord          `json:"holds,omitempty"`
+	LastHold   uint64                `json:"last_hold,omitempty"`
+	Overdrafts map[int]int           `json:"overdrafts,omitempty"`
+}
+
+// writeFileAtomic replaces path with data so that a crash leaves either the
+// old or the new contents
+func writeFileAtomic(path string, data []byte) error {
+	f, err := replaceFile(path, data)
+	if f != nil {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// replaceFile is writeFileAtomic, returning the new file still open for
+// appending. The file is returned, even with an error, once it has replaced
+// path; on any earlier error path is untouched.
+func replaceFile(path string, data []byte) (*os.File, error) {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return f, err
+	}
+	defer dir.Close()
+	return f, dir.Sync()
+}
+
+// PersistOptions configures a durable bank
+type PersistOptions struct {
+	// CheckpointInterval writes a snapshot and compacts the WAL this often.
+	// Zero disables periodic checkpoints; Checkpoint can still be called.
+	CheckpointInterval time.Duration
+}
+
+// persistence is the state of a bank opened with OpenBank
+type persistence struct {
+	dir  string
+	mu   sync.Mutex // serializes checkpoints
+	stop chan struct{}
+	done chan struct{}
+}
+
+// OpenBank opens a durable bank stored in dir, recovering every committed
+// transaction from the last snapshot and the WAL
+func OpenBank(dir string, opts PersistOptions) (*Bank, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	b := NewBank()
+	j := &b.journal
+
+	data, err := os.ReadFile(filepath.Join(dir, snapshotFile))
+	switch {
+	case err == nil:
+		var snap snapshot
+		if err := json.Unmarshal(data, &snap); err != nil {
+			return nil, fmt.Errorf("reading snapshot: %w", err)
+		}
+		j.baseSeq = snap.Seq
+		j.baseTime = snap.Time
+		j.base = snap.Balances
+		j.baseCur = snap.Currencies
+		j.accrued = snap.Accrued
+		j.status = snap.Statuses
+		j.keys = snap.Keys
+		for _, h := range snap.Holds {
+			j.indexState(walRecord{Hold: &h})
+		}
+		j.lastHold = snap.LastHold
+		j.overdrafts = snap.Overdrafts
+	case !errors.Is(err, os.ErrNotExist):
+		return nil, err
+	}
+
+	records, err := readWAL(filepath.Join(dir, walFile))
+	if err != nil {
+		return nil, err
+	}
+	snapHold := j.lastHold
+	for _, rec := range records {
+		switch {
+		case rec.Hold != nil && rec.Hold.ID <= snapHold:
+			// Hold IDs only grow, so the snapshot already says whether this
+			// hold is open; compaction did not finish
+			continue
+		case rec.Hold != nil, rec.Release != 0, rec.Overdraft != nil:
+			j.indexState(rec)
+			continue
+		case rec.Schedule != nil:
+			b.putSchedule(*rec.Schedule)
+			continue
+		case rec.Unschedule != 0:
+			b.deleteSchedule(rec.Unschedule)
+			continue
+		case rec.Entry == nil:
+			continue
+		}
+		e := *rec.Entry
+		next := j.baseSeq + uint64(len(j.entries)) + 1
+		switch {
+		case e.Seq < next:
+			// Already folded into the snapshot; compaction did not finish
+			continue
+		case e.Seq > next:
+			return nil, fmt.Errorf("%w: expected entry %d, found %d", ErrCorruptWAL, next, e.Seq)
+		}
+		j.entries = append(j.entries, e)
+		j.index(e)
+	}
+
+	// Rebuild accounts from the replayed balances
+	seq := j.baseSeq + uint64(len(j.entries))
+	currencies := j.currencies(seq)
+	for id, balance := range j.replay(seq) {
+		account := &Account{
+			id:       id,
+			currency: cmp.Or(currencies[id], DefaultCurrency),
+			status:   cmp.Or(j.status[id], StatusOpen),
+		}
+		account.setBalance(seq, balance, 0)
+		b.shardOf(id).accounts[id] = account
+		b.lastID.Store(max(b.lastID.Load(), int64(id)))
+	}
+
+	j.commits.applied = seq
+	b.idempotency.restore(j.keys)
+
+	j.wal, err = openWAL(filepath.Join(dir, walFile))
+	if err != nil {
+		return nil, err
+	}
+
+	// Holds that expired while the bank was down are released through the
+	// WAL, so it must be open first
+	b.restoreHolds()
+
+	b.persist = &persistence{dir: dir, stop: make(chan struct{}), done: make(chan struct{})}
+	go b.checkpointLoop(opts.CheckpointInterval)
+
+	log.Printf("Recovered %d accounts at sequence %d\n", len(b.allAccounts()), j.LastSeq())
+	return b, nil
+}
+
+// checkpointLoop runs Checkpoint every interval until Close
+func (b *Bank) checkpointLoop(interval time.Duration) {
+	defer close(b.persist.done)
+	if interval <= 0 {
+		<-b.persist.stop
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := b.Checkpoint(); err != nil {
+				log.Printf("Checkpoint failed: %v\n", err)
+			}
+		case <-b.persist.stop:
+			return
+		}
+	}
+}
+
+// Checkpoint writes a snapshot of every balance and compacts the WAL, and
+// the journal in memory, down to the entries committed since. Transfers keep running while the snapshot is
+// written; they only pause while the WAL is swapped.
+func (b *Bank) Checkpoint() error {
+	if b.persist == nil {
+		return errors.New("checkpoint: bank is not persistent")
+	}
+	b.persist.mu.Lock()
+	defer b.persist.mu.Unlock()
+
+	b.idempotency.mu.Lock()
+	cutoff := b.idempotency.cutoff(time.Now())
+	b.idempotency.mu.Unlock()
+
+	j := &b.journal
+	j.mu.RLock()
+	snap := snapshot{Seq: j.baseSeq + uint64(len(j.entries)), Time: j.baseTime}
+	if len(j.entries) > 0 {
+		snap.Time = j.entries[len(j.entries)-1].Time
+	}
+	snap.Balances = j.replay(snap.Seq)
+	snap.Currencies = j.currencies(snap.Seq)
+	snap.Accrued = maps.Clone(j.accrued)
+	snap.Statuses = maps.Clone(j.status)
+	snap.Keys = retainedKeys(j.keys, cutoff)
+	for _, h := range j.holds {
+		snap.Holds = append(snap.Holds, h)
+	}
+	slices.SortFunc(snap.Holds, func(x, y holdRecord) int { return cmp.Compare(x.ID, y.ID) })
+	snap.LastHold = j.lastHold
+	snap.Overdrafts = maps.Clone(j.overdrafts)
+	logged := len(j.logged)
+	j.mu.RUnlock()
+
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(b.persist.dir, snapshotFile), data); err != nil {
+		return fmt.Errorf("writing snapshot: %w", err)
+	}
+
+	// Rewrite the WAL with only the entries after the snapshot, and the hold
+	// and overdraft records logged among them, plus every scheduled transfer
+	b.schedules.mu.Lock()
+	defer b.schedules.mu.Unlock()
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.keys = retainedKeys(j.keys, cutoff)
+
+	var buf []byte
+	add := func(r walRecord) error {
+		rec, err := encodeRecord(r)
+		if err != nil {
+			return err
+		}
+		buf = append(buf, rec...)
+		return nil
+	}
+	for _, st := range b.schedules.byID {
+		if err := add(walRecord{Schedule: st}); err != nil {
+			return err
+		}
+	}
+	later := j.logged[logged:]
+	for _, e := range j.entries {
+		if e.Seq <= snap.Seq {
+			continue
+		}
+		for ; len(later) > 0 && later[0].seq < e.Seq; later = later[1:] {
+			if err := add(later[0].rec); err != nil {
+				return err
+			}
+		}
+		if err := add(walRecord{Entry: &e}); err != nil {
+			return err
+		}
+	}
+	for _, l := range later {
+		if err := add(l.rec); err != nil {
+			return err
+		}
+	}
+
+	// The new log is opened before it replaces the old one, so a failure
+	// leaves the bank appending to whichever log is in place
+	path := filepath.Join(b.persist.dir, walFile)
+	f, err := replaceFile(path, buf)
+	if f != nil {
+		j.wal.Close()
+		j.wal = &WAL{path: path, f: f}
+		j.logged = slices.Clone(j.logged[logged:])
+
+		// The entries up to the snapshot are gone from the WAL, so keep only
+		// their net effect in memory too
+		j.entries = slices.Clone(j.entries[snap.Seq-j.baseSeq:])
+		j.baseSeq, j.baseTime = snap.Seq, snap.Time
+		j.base, j.baseCur = snap.Balances, snap.Currencies
+	}
+	if err != nil {
+		return fmt.Errorf("compacting WAL: %w", err)
+	}
+	return nil
+}
+
+// retainedKeys returns the keys committed after cutoff
+func retainedKeys(keys []keyRecord, cutoff time.Time) []keyRecord {
+	i, _ := slices.BinarySearchFunc(keys, cutoff, func(k keyRecord, t time.Time) int {
+		return k.Time.Compare(t)
+	})
+	return slices.Clone(keys[i:])
+}
+
+// Close stops periodic checkpoints and closes the WAL
+func (b *Bank) Close() error {
+	if b.persist == nil {
+		return nil
+	}
+	close(b.persist.stop)
+	<-b.persist.done
+
+	b.journal.mu.Lock()
+	defer b.journal.mu.Unlock()
+	return b.journal.wal.Close()
+}
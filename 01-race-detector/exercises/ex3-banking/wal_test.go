@@ -0,0 +1,326 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestWAL writes a WAL of one open entry per balance and returns its
+// path and the offset at which each record starts
+func writeTestWAL(t *testing.T, dir string, balances ...int) (string, []int64) {
+	t.Helper()
+	var buf []byte
+	var offsets []int64
+	for i, balance := range balances {
+		rec, err := encodeRecord(walRecord{Entry: &JournalEntry{
+			Seq:  uint64(i + 1),
+			Kind: EntryOpen,
+			Lines: []Posting{
+				{AccountID: i + 1, Amount: balance, Currency: USD},
+				{AccountID: EquityAccount, Amount: -balance, Currency: USD},
+			},
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		offsets = append(offsets, int64(len(buf)))
+		buf = append(buf, rec...)
+	}
+	path := filepath.Join(dir, walFile)
+	if err := os.WriteFile(path, buf, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path, offsets
+}
+
+func TestReadWAL(t *testing.T) {
+	tests := []struct {
+		name    string
+		damage  func(data []byte, offsets []int64) []byte
+		want    int // records recovered
+		wantErr error
+	}{
+		{
+			name:   "intact",
+			damage: func(data []byte, _ []int64) []byte { return data },
+			want:   3,
+		},
+		{
+			name:   "torn header",
+			damage: func(data []byte, offsets []int64) []byte { return data[:offsets[2]+3] },
+			want:   2,
+		},
+		{
+			name:   "torn payload",
+			damage: func(data []byte, _ []int64) []byte { return data[:len(data)-5] },
+			want:   2,
+		},
+		{
+			name: "bad checksum in last record",
+			damage: func(data []byte, _ []int64) []byte {
+				data[len(data)-2] ^= 0xff
+				return data
+			},
+			want: 2,
+		},
+		{
+			name: "bad checksum mid-log",
+			damage: func(data []byte, offsets []int64) []byte {
+				data[offsets[1]+walHeaderSize+2] ^= 0xff
+				return data
+			},
+			wantErr: ErrCorruptWAL,
+		},
+		{
+			name: "bad length mid-log",
+			damage: func(data []byte, offsets []int64) []byte {
+				binary.LittleEndian.PutUint32(data[offsets[1]:], 1<<20)
+				return data
+			},
+			wantErr: ErrCorruptWAL,
+		},
+		{
+			name: "bad length in last record",
+			damage: func(data []byte, offsets []int64) []byte {
+				binary.LittleEndian.PutUint32(data[offsets[2]:], 1<<20)
+				return data
+			},
+			want: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, offsets := writeTestWAL(t, t.TempDir(), 100, 200, 300)
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, tt.damage(data, offsets), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			records, err := readWAL(path)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("readWAL error = %v, want %v", err, tt.wantErr)
+			}
+			if len(records) != tt.want {
+				t.Fatalf("recovered %d records, want %d", len(records), tt.want)
+			}
+			if err != nil {
+				return
+			}
+
+			// A torn tail is truncated, so the next read finds a clean log
+			again, err := readWAL(path)
+			if err != nil || len(again) != tt.want {
+				t.Errorf("second read: %d records, %v; want %d", len(again), err, tt.want)
+			}
+		})
+	}
+}
+
+func TestOpenBankRecovers(t *testing.T) {
+	tests := []struct {
+		name       string
+		checkpoint bool
+	}{
+		{"from WAL", false},
+		{"from snapshot and WAL", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			b, err := OpenBank(dir, PersistOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			from, _ := b.OpenAccount(1000)
+			to, _ := b.OpenAccount(0)
+			if err := b.transfer(from, to, 300); err != nil {
+				t.Fatal(err)
+			}
+			if tt.checkpoint {
+				if err := b.Checkpoint(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// Committed after the checkpoint, so only in the compacted WAL
+			if err := b.transfer(to, from, 100); err != nil {
+				t.Fatal(err)
+			}
+			seq := b.journal.LastSeq()
+			if err := b.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			b, err = OpenBank(dir, PersistOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer b.Close()
+			if got := b.journal.LastSeq(); got != seq {
+				t.Errorf("recovered to seq %d, want %d", got, seq)
+			}
+			if gotFrom, gotTo := b.GetBalance(from), b.GetBalance(to); gotFrom != 800 || gotTo != 200 {
+				t.Errorf("balances %d and %d, want 800 and 200", gotFrom, gotTo)
+			}
+			if diffs, err := b.Reconcile(); err != nil || len(diffs) > 0 {
+				t.Errorf("Reconcile: %v, %v", diffs, err)
+			}
+		})
+	}
+}
+
+func TestOpenBankRecoversHolds(t *testing.T) {
+	tests := []struct {
+		name       string
+		checkpoint bool
+	}{
+		{"from WAL", false},
+		{"from snapshot and WAL", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			b, err := OpenBank(dir, PersistOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			b.SetFraudEngine(NewFraudEngine(10, LargeAmountRule{Threshold: 400, Period: time.Minute, Do: HoldForReview}))
+			a, _ := b.OpenAccount(1000)
+			payee, _ := b.OpenAccount(0)
+			if err := b.SetOverdraftLimit(a, 300); err != nil {
+				t.Fatal(err)
+			}
+			open, _ := b.Authorize(a, 100, 0)
+			released, _ := b.Authorize(a, 50, 0)
+			captured, _ := b.Authorize(a, 20, 0)
+			if err := b.Release(released); err != nil {
+				t.Fatal(err)
+			}
+			if err := b.Capture(captured, payee, 20); err != nil {
+				t.Fatal(err)
+			}
+			if tt.checkpoint {
+				if err := b.Checkpoint(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// Logged after the checkpoint, so only in the compacted WAL
+			var held *HeldError
+			if err := b.transfer(a, payee, 500); !errors.As(err, &held) {
+				t.Fatalf("transfer error = %v, want a HeldError", err)
+			}
+			expiring, _ := b.Authorize(a, 10, 20*time.Millisecond)
+			if err := b.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			time.Sleep(40 * time.Millisecond)
+			b, err = OpenBank(dir, PersistOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer b.Close()
+
+			// The hold expired while the bank was down, and is
+			// released once it reopens
+			waitFor(t, func() bool { _, err := b.holds.lookup(expiring); return err != nil })
+
+			// 980 less the open hold and the review hold, plus the overdraft
+			if got, _ := b.Available(a); got != 980-100-500+300 {
+				t.Errorf("available = %d, want %d", got, 980-100-500+300)
+			}
+			for _, id := range []uint64{released, captured} {
+				if err := b.Release(id); !errors.Is(err, ErrUnknownHold) {
+					t.Errorf("Release(%d) = %v, want %v", id, err, ErrUnknownHold)
+				}
+			}
+			// The review hold can still be approved, only to its payee, and a
+			// new hold does not reuse an old ID
+			if err := b.Capture(held.HoldID, payee, 500); err != nil {
+				t.Fatal(err)
+			}
+			if id, _ := b.Authorize(a, 1, 0); id <= expiring {
+				t.Errorf("new hold ID %d reuses an old one", id)
+			}
+			if err := b.Release(open); err != nil {
+				t.Fatal(err)
+			}
+			if gotA, gotPayee := b.GetBalance(a), b.GetBalance(payee); gotA != 480 || gotPayee != 520 {
+				t.Errorf("balances %d and %d, want 480 and 520", gotA, gotPayee)
+			}
+		})
+	}
+}
+
+// waitFor polls cond until it holds, failing the test after a second
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); !cond(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met within a second")
+		}
+	}
+}
+
+func TestCheckpointTrimsJournal(t *testing.T) {
+	b, err := OpenBank(t.TempDir(), PersistOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	from, _ := b.OpenAccount(1000)
+	to, _ := b.OpenAccount(0)
+	for range 5 {
+		if err := b.transfer(from, to, 10); err != nil {
+			t.Fatal(err)
+		}
+	}
+	seq := b.journal.LastSeq()
+	if err := b.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(b.journal.Entries()); n != 0 {
+		t.Errorf("%d entries held after checkpoint, want 0", n)
+	}
+
+	time.Sleep(time.Millisecond)
+	compacted := time.Now()
+	if err := b.transfer(from, to, 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+	recent := time.Now()
+	if err := b.transfer(to, from, 5); err != nil {
+		t.Fatal(err)
+	}
+
+	entries := b.journal.Entries()
+	if len(entries) != 1 || entries[0].Seq != seq+2 {
+		t.Fatalf("entries after second checkpoint = %+v, want only seq %d", entries, seq+2)
+	}
+	if got := b.journal.LastSeq(); got != seq+2 {
+		t.Errorf("LastSeq = %d, want %d", got, seq+2)
+	}
+	if diffs, err := b.Reconcile(); err != nil || len(diffs) > 0 {
+		t.Errorf("Reconcile: %v, %v", diffs, err)
+	}
+	if _, err := b.Statement(from, compacted, time.Now()); !errors.Is(err, ErrHistoryCompacted) {
+		t.Errorf("statement from before the checkpoint: %v, want %v", err, ErrHistoryCompacted)
+	}
+	s, err := b.Statement(from, recent, time.Now().Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Opening != 940 || s.Closing != 945 {
+		t.Errorf("statement opening %d, closing %d; want 940 and 945", s.Opening, s.Closing)
+	}
+}
@@ -8,6 +8,8 @@ import (
 	"runtime/pprof"
 	"sync"
 	"time"
+
+	"github.com/derekparker/gophercon-2025/03-delve/demo/supervisor"
 )
 
 // Order represents a customer order to process
@@ -32,6 +34,11 @@ type Pipeline struct {
 	shipping   chan ProcessedOrder
 	done       chan bool
 	wg         sync.WaitGroup
+
+	// Panic recovery for processors, see Supervise
+	supervisor *supervisor.Supervisor
+	mu         sync.Mutex
+	failed     []*supervisor.PanicError
 }
 
 func NewPipeline() *Pipeline {
@@ -51,9 +58,14 @@ func (p *Pipeline) Start() {
 	go p.validator()
 
 	// Start processing workers
-	for i := 1; i <= 2; i++ {
-		p.wg.Add(1)
-		go p.processor(fmt.Sprintf("processor-%d", i))
+	names := []string{"processor-1", "processor-2"}
+	if p.supervisor != nil {
+		p.startSupervised(names)
+	} else {
+		for _, name := range names {
+			p.wg.Add(1)
+			go p.processor(name)
+		}
 	}
 
 	// Start shipping worker
@@ -107,26 +119,44 @@ func (p *Pipeline) validator() {
 // processor handles order processing
 func (p *Pipeline) processor(name string) {
 	ls := pprof.Labels("job", "processor")
-	pprof.Do(context.Background(), ls, func(_ context.Context) {
+	pprof.Do(context.Background(), ls, func(ctx context.Context) {
 		defer p.wg.Done()
-		for order := range p.processing {
-			log.Printf("[%s] Processing order %d\n", name, order.ID)
-
-			// Simulate processing work (varies by priority)
-			duration := time.Duration(500-order.Priority*100) * time.Millisecond
-			time.Sleep(duration)
+		p.process(ctx, name, func(any) {})
+	})
+}
 
-			processed := ProcessedOrder{
-				Order:       order,
-				ProcessedAt: time.Now(),
-				ProcessedBy: name,
+// process runs the processor loop until processing closes or ctx is
+// cancelled, calling track with each order before working on it
+func (p *Pipeline) process(ctx context.Context, name string, track func(item any)) {
+	for {
+		var order Order
+		select {
+		case next, ok := <-p.processing:
+			if !ok {
+				return
 			}
+			order = next
+		case <-ctx.Done():
+			return
+		}
+		track(order)
+
+		log.Printf("[%s] Processing order %d\n", name, order.ID)
+
+		// Simulate processing work (varies by priority)
+		duration := time.Duration(500-order.Priority*100) * time.Millisecond
+		time.Sleep(duration)
 
-			log.Printf("[%s] Completed order %d, sending to shipping\n", name, order.ID)
-			// BUG: This can block if shipper is busy
-			p.shipping <- processed
+		processed := ProcessedOrder{
+			Order:       order,
+			ProcessedAt: time.Now(),
+			ProcessedBy: name,
 		}
-	})
+
+		log.Printf("[%s] Completed order %d, sending to shipping\n", name, order.ID)
+		// BUG: This can block if shipper is busy
+		p.shipping <- processed
+	}
 }
 
 // shipper handles the final shipping stage
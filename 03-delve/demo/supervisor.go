@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"log"
+	"runtime/pprof"
+	"time"
+
+	"github.com/derekparker/gophercon-2025/03-delve/demo/supervisor"
+)
+
+// Supervise runs the order processors under a supervisor, so a panicking
+// processor is restarted instead of crashing the pipeline. Must be called
+// before Start.
+func (p *Pipeline) Supervise(strategy supervisor.Strategy, maxRestarts int, window time.Duration) {
+	p.supervisor = supervisor.New(strategy, maxRestarts, window, p.handlePanic)
+}
+
+// Failed returns every order a processor panicked on
+func (p *Pipeline) Failed() []*supervisor.PanicError {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]*supervisor.PanicError(nil), p.failed...)
+}
+
+// startSupervised launches the processors as children of p.supervisor
+func (p *Pipeline) startSupervised(names []string) {
+	for _, name := range names {
+		p.supervisor.Add(name, func(ctx context.Context, track func(any)) {
+			pprof.Do(ctx, pprof.Labels("job", "processor"), func(ctx context.Context) {
+				p.process(ctx, name, track)
+			})
+		})
+	}
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		if err := p.supervisor.Run(context.Background()); err != nil {
+			log.Printf("[SUPERVISOR] Stopped: %v\n", err)
+		}
+	}()
+}
+
+// handlePanic records the order a processor panicked on
+func (p *Pipeline) handlePanic(e *supervisor.PanicError) {
+	log.Printf("[SUPERVISOR] %v\n%s", e, e.Stack)
+
+	p.mu.Lock()
+	p.failed = append(p.failed, e)
+	p.mu.Unlock()
+}
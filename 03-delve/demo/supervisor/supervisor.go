@@ -0,0 +1,194 @@
+// Package supervisor restarts goroutines that panic, Erlang style. It is
+// shared by the Delve demo pipeline and the fan-out/fan-in exercise.
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"time"
+)
+
+// Strategy decides which children are restarted when one of them panics
+type Strategy int
+
+const (
+	// OneForOne restarts only the child that panicked
+	OneForOne Strategy = iota
+	// AllForOne stops every other child and restarts them all together
+	AllForOne
+)
+
+// ErrTooManyRestarts is returned by Run when children panic more often than
+// the restart limit allows
+var ErrTooManyRestarts = errors.New("supervisor: restart limit exceeded")
+
+// ChildFunc is the body of a supervised goroutine. It should return when ctx
+// is cancelled, and call track with each item before working on it so that a
+// panic can be attributed to the item that caused it.
+type ChildFunc func(ctx context.Context, track func(item any))
+
+// PanicError describes a child that panicked
+type PanicError struct {
+	Child string
+	Item  any // last item passed to track, nil if none
+	Value any // value passed to panic
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("%s panicked on item %v: %v", e.Child, e.Item, e.Value)
+}
+
+// child is one supervised goroutine
+type child struct {
+	name string
+	fn   ChildFunc
+}
+
+// exit reports that a child goroutine has returned
+type exit struct {
+	child *child
+	panic *PanicError // nil if the child returned normally
+}
+
+// Supervisor runs a set of goroutines and restarts them when they panic,
+// Erlang style. Children that return normally are not restarted.
+type Supervisor struct {
+	strategy    Strategy
+	maxRestarts int
+	window      time.Duration
+	onPanic     func(*PanicError)
+
+	children []*child
+	restarts []time.Time
+}
+
+// New creates a supervisor that allows at most maxRestarts restarts
+// within any window. onPanic is called with every captured panic before the
+// restart happens.
+func New(strategy Strategy, maxRestarts int, window time.Duration, onPanic func(*PanicError)) *Supervisor {
+	return &Supervisor{
+		strategy:    strategy,
+		maxRestarts: maxRestarts,
+		window:      window,
+		onPanic:     onPanic,
+	}
+}
+
+// Add registers a child. Must be called before Run.
+func (s *Supervisor) Add(name string, fn ChildFunc) {
+	s.children = append(s.children, &child{name: name, fn: fn})
+}
+
+// Run starts every child and blocks until they have all returned normally.
+// It returns ErrTooManyRestarts if the restart limit is hit, or ctx.Err() if
+// ctx is cancelled; in both cases every child has exited before Run returns.
+func (s *Supervisor) Run(ctx context.Context) error {
+	exits := make(chan exit)
+
+	// Children run in a group that AllForOne replaces on every restart
+	type group struct {
+		ctx    context.Context
+		cancel context.CancelFunc
+	}
+	newGroup := func() *group {
+		groupCtx, cancel := context.WithCancel(ctx)
+		return &group{groupCtx, cancel}
+	}
+	g := newGroup()
+	defer func() { g.cancel() }()
+
+	running := 0
+	start := func(c *child) {
+		running++
+		go s.spawn(g.ctx, c, exits)
+	}
+	for _, c := range s.children {
+		start(c)
+	}
+
+	// stopAll cancels the current group and waits for its children,
+	// returning the ones that did not finish on their own
+	stopAll := func() []*child {
+		g.cancel()
+		var stopped []*child
+		for ; running > 0; running-- {
+			e := <-exits
+			if e.panic != nil {
+				s.onPanic(e.panic)
+			}
+			stopped = append(stopped, e.child)
+		}
+		return stopped
+	}
+
+	for running > 0 {
+		e := <-exits
+		running--
+		if e.panic == nil {
+			continue
+		}
+
+		s.onPanic(e.panic)
+		if ctx.Err() != nil {
+			stopAll()
+			return ctx.Err()
+		}
+		if !s.allowRestart() {
+			log.Printf("Supervisor: restart limit of %d per %v exceeded, giving up\n",
+				s.maxRestarts, s.window)
+			stopAll()
+			return ErrTooManyRestarts
+		}
+
+		switch s.strategy {
+		case OneForOne:
+			log.Printf("Supervisor: restarting %s\n", e.child.name)
+			start(e.child)
+		case AllForOne:
+			restart := append(stopAll(), e.child)
+			g = newGroup()
+			for _, c := range restart {
+				log.Printf("Supervisor: restarting %s\n", c.name)
+				start(c)
+			}
+		}
+	}
+
+	return ctx.Err()
+}
+
+// spawn runs one child, converting a panic into a PanicError
+func (s *Supervisor) spawn(ctx context.Context, c *child, exits chan<- exit) {
+	var current any
+	defer func() {
+		e := exit{child: c}
+		if r := recover(); r != nil {
+			e.panic = &PanicError{
+				Child: c.name,
+				Item:  current,
+				Value: r,
+				Stack: debug.Stack(),
+			}
+		}
+		exits <- e
+	}()
+
+	c.fn(ctx, func(item any) { current = item })
+}
+
+// allowRestart records a restart and reports whether it is within the limit
+func (s *Supervisor) allowRestart() bool {
+	now := time.Now()
+	recent := s.restarts[:0]
+	for _, t := range s.restarts {
+		if now.Sub(t) < s.window {
+			recent = append(recent, t)
+		}
+	}
+	s.restarts = append(recent, now)
+	return len(s.restarts) <= s.maxRestarts
+}
@@ -0,0 +1,103 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRunRestartsPanickingChild(t *testing.T) {
+	tests := []struct {
+		name        string
+		strategy    Strategy
+		panics      int // times the flaky child panics before it succeeds
+		maxRestarts int
+		wantErr     error
+	}{
+		{"one for one, single panic", OneForOne, 1, 5, nil},
+		{"one for one, several panics", OneForOne, 3, 5, nil},
+		{"all for one, several panics", AllForOne, 3, 5, nil},
+		{"one for one, over the limit", OneForOne, 3, 2, ErrTooManyRestarts},
+		{"all for one, over the limit", AllForOne, 3, 2, ErrTooManyRestarts},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var caught []*PanicError
+			s := New(tt.strategy, tt.maxRestarts, time.Minute, func(p *PanicError) {
+				caught = append(caught, p)
+			})
+
+			// The flaky child panics on its first items, then finishes.
+			// The steady child runs until the flaky one has finished, or
+			// until it is stopped for an AllForOne restart.
+			var attempts, steadyStarts atomic.Int32
+			flakyDone := make(chan struct{})
+			s.Add("flaky", func(ctx context.Context, track func(any)) {
+				n := attempts.Add(1)
+				track(int(n))
+				if int(n) <= tt.panics {
+					panic("boom")
+				}
+				close(flakyDone)
+			})
+			s.Add("steady", func(ctx context.Context, track func(any)) {
+				steadyStarts.Add(1)
+				select {
+				case <-flakyDone:
+				case <-ctx.Done():
+				}
+			})
+
+			errc := make(chan error, 1)
+			go func() { errc <- s.Run(context.Background()) }()
+			var err error
+			select {
+			case err = <-errc:
+			case <-time.After(5 * time.Second):
+				t.Fatal("Run did not return")
+			}
+
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Run = %v, want %v", err, tt.wantErr)
+			}
+			wantCaught := min(tt.panics, tt.maxRestarts+1)
+			if len(caught) != wantCaught {
+				t.Fatalf("onPanic called %d times, want %d", len(caught), wantCaught)
+			}
+			for i, p := range caught {
+				if p.Child != "flaky" || p.Item != i+1 || p.Value != "boom" {
+					t.Errorf("panic %d = %+v, want flaky on item %d", i, p, i+1)
+				}
+			}
+			if tt.wantErr == nil {
+				wantStarts := int32(1)
+				if tt.strategy == AllForOne {
+					wantStarts += int32(tt.panics)
+				}
+				if got := steadyStarts.Load(); got != wantStarts {
+					t.Errorf("steady child started %d times, want %d", got, wantStarts)
+				}
+			}
+		})
+	}
+}
+
+func TestRunStopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := New(OneForOne, 1, time.Minute, func(*PanicError) {})
+	s.Add("waiter", func(ctx context.Context, track func(any)) { <-ctx.Done() })
+
+	errc := make(chan error, 1)
+	go func() { errc <- s.Run(ctx) }()
+	cancel()
+	select {
+	case err := <-errc:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Run = %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after cancel")
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"time"
+)
+
+// LatePolicy decides what happens to items that arrive after their window
+// has already been emitted
+type LatePolicy int
+
+const (
+	// DropLate discards late items silently
+	DropLate LatePolicy = iota
+	// CountLate discards late items but reports how many there were in the
+	// next WindowResult
+	CountLate
+)
+
+// WindowSpec describes how ProcessedData results are grouped into windows.
+// Set Size for time windows or Count for count windows.
+type WindowSpec struct {
+	// Size is the length of a time window. Windows start on multiples of
+	// Slide (or Size for tumbling windows).
+	Size time.Duration
+	// Slide starts a new time window every Slide. Zero gives tumbling windows.
+	Slide time.Duration
+
+	// Count is the number of items in a count window.
+	Count int
+	// CountSlide starts a new count window every CountSlide items. Zero gives
+	// tumbling windows.
+	CountSlide int
+
+	// AllowedLateness holds time windows open this long past the newest
+	// timestamp seen. The watermark is the newest timestamp minus
+	// AllowedLateness; a window is emitted once its end passes the watermark.
+	AllowedLateness time.Duration
+	Late            LatePolicy
+
+	// Percentiles to compute for each window, between 0 and 1
+	Percentiles []float64
+}
+
+// WindowResult holds the aggregates for one window of results
+type WindowResult struct {
+	// Start and End bound a time window; both are zero for count windows
+	Start time.Time
+	End   time.Time
+
+	Count       int
+	Sum         int
+	Min         int
+	Max         int
+	Percentiles map[float64]int
+
+	// Late is the number of late items dropped since the previous result,
+	// only set with CountLate. Late items that arrive after the last window
+	// has been emitted are reported in a final result with no window, only
+	// Late set.
+	Late int
+}
+
+// ErrInvalidWindowSpec is returned by Aggregate for a WindowSpec it cannot use
+var ErrInvalidWindowSpec = errors.New("invalid window spec")
+
+// validate checks that exactly one kind of window is set and that every
+// field is in range
+func (s WindowSpec) validate() error {
+	switch {
+	case (s.Size > 0) == (s.Count > 0):
+		return fmt.Errorf("%w: set exactly one of Size and Count", ErrInvalidWindowSpec)
+	case s.Size < 0 || s.Slide < 0 || s.AllowedLateness < 0:
+		return fmt.Errorf("%w: negative duration", ErrInvalidWindowSpec)
+	case s.Count < 0 || s.CountSlide < 0:
+		return fmt.Errorf("%w: negative count", ErrInvalidWindowSpec)
+	}
+	for _, p := range s.Percentiles {
+		if p < 0 || p > 1 {
+			return fmt.Errorf("%w: percentile %v is not between 0 and 1", ErrInvalidWindowSpec, p)
+		}
+	}
+	return nil
+}
+
+// Aggregate computes rolling aggregates of Result over in, using each
+// result's Timestamp as its event time. Results are emitted on the returned
+// channel in window order, and every window still open is flushed when in
+// closes. The channel is closed when in closes or ctx is cancelled.
+func Aggregate(ctx context.Context, in <-chan ProcessedData, spec WindowSpec) (<-chan WindowResult, error) {
+	if err := spec.validate(); err != nil {
+		return nil, err
+	}
+	if spec.Count > 0 {
+		return aggregateCount(ctx, in, spec), nil
+	}
+	return aggregateTime(ctx, in, spec), nil
+}
+
+// aggregateCount builds count windows on top of the Window combinator
+func aggregateCount(ctx context.Context, in <-chan ProcessedData, spec WindowSpec) <-chan WindowResult {
+	step := spec.CountSlide
+	if step <= 0 {
+		step = spec.Count
+	}
+
+	out := make(chan WindowResult)
+	go func() {
+		defer close(out)
+		for window := range Window(ctx, in, spec.Count, step) {
+			values := make([]int, len(window))
+			for i, r := range window {
+				values[i] = r.Result
+			}
+			if !send(ctx, out, summarize(values, spec.Percentiles)) {
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// aggregateTime builds event-time windows with a watermark
+func aggregateTime(ctx context.Context, in <-chan ProcessedData, spec WindowSpec) <-chan WindowResult {
+	slide := spec.Slide
+	if slide <= 0 {
+		slide = spec.Size
+	}
+
+	out := make(chan WindowResult)
+	go func() {
+		defer close(out)
+
+		open := make(map[int64][]int) // window start (ns) -> values
+		var newest time.Time
+		var watermark time.Time
+		late := 0
+
+		// emit sends every window ending at or before limit, oldest first
+		emit := func(limit time.Time, all bool) bool {
+			var starts []int64
+			for start := range open {
+				end := time.Unix(0, start).Add(spec.Size)
+				if all || !end.After(limit) {
+					starts = append(starts, start)
+				}
+			}
+			slices.Sort(starts)
+
+			for _, start := range starts {
+				result := summarize(open[start], spec.Percentiles)
+				result.Start = time.Unix(0, start)
+				result.End = result.Start.Add(spec.Size)
+				result.Late, late = late, 0
+				delete(open, start)
+				if !send(ctx, out, result) {
+					return false
+				}
+			}
+			return true
+		}
+
+		for {
+			var r ProcessedData
+			select {
+			case next, ok := <-in:
+				if !ok {
+					if emit(time.Time{}, true) && late > 0 {
+						send(ctx, out, WindowResult{Late: late})
+					}
+					return
+				}
+				r = next
+			case <-ctx.Done():
+				return
+			}
+
+			ts := r.Timestamp
+			if ts.After(newest) {
+				newest = ts
+				watermark = newest.Add(-spec.AllowedLateness)
+			}
+
+			// Add the value to every window containing ts that is
+			// still open. With Slide > Size, ts may fall between windows;
+			// that is not late.
+			covered, assigned := false, false
+			first := ts.Truncate(slide)
+			for start := first; start.Add(spec.Size).After(ts); start = start.Add(-slide) {
+				covered = true
+				if !start.Add(spec.Size).After(watermark) && !watermark.IsZero() {
+					continue
+				}
+				open[start.UnixNano()] = append(open[start.UnixNano()], r.Result)
+				assigned = true
+			}
+			if covered && !assigned && spec.Late == CountLate {
+				late++
+			}
+
+			if !emit(watermark, false) {
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// summarize computes the aggregates for one window's values
+func summarize(values []int, percentiles []float64) WindowResult {
+	result := WindowResult{Count: len(values)}
+	if len(values) == 0 {
+		return result
+	}
+
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+
+	result.Min = sorted[0]
+	result.Max = sorted[len(sorted)-1]
+	for _, v := range sorted {
+		result.Sum += v
+	}
+
+	if len(percentiles) > 0 {
+		result.Percentiles = make(map[float64]int, len(percentiles))
+		for _, p := range percentiles {
+			// Nearest-rank percentile
+			rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+			rank = max(0, min(rank, len(sorted)-1))
+			result.Percentiles[p] = sorted[rank]
+		}
+	}
+	return result
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAggregateRejectsBadSpec(t *testing.T) {
+	tests := []struct {
+		name string
+		spec WindowSpec
+	}{
+		{"no window", WindowSpec{}},
+		{"both kinds", WindowSpec{Size: time.Second, Count: 2}},
+		{"negative slide", WindowSpec{Size: time.Second, Slide: -time.Second}},
+		{"negative count slide", WindowSpec{Count: 2, CountSlide: -1}},
+		{"percentile out of range", WindowSpec{Count: 2, Percentiles: []float64{1.5}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Aggregate(context.Background(), make(chan ProcessedData), tt.spec); !errors.Is(err, ErrInvalidWindowSpec) {
+				t.Errorf("got %v, want ErrInvalidWindowSpec", err)
+			}
+		})
+	}
+}
+
+func TestAggregateTimeWindows(t *testing.T) {
+	base := time.Unix(1000, 0)
+	at := func(seconds, result int) ProcessedData {
+		return ProcessedData{Result: result, Timestamp: base.Add(time.Duration(seconds) * time.Second)}
+	}
+
+	tests := []struct {
+		name   string
+		spec   WindowSpec
+		inputs []ProcessedData
+		want   []WindowResult // only Count, Sum and Late are compared
+	}{
+		{
+			name:   "in order",
+			spec:   WindowSpec{Size: 10 * time.Second},
+			inputs: []ProcessedData{at(0, 1), at(5, 2), at(10, 3), at(15, 4)},
+			want:   []WindowResult{{Count: 2, Sum: 3}, {Count: 2, Sum: 7}},
+		},
+		{
+			name:   "late item reported in next window",
+			spec:   WindowSpec{Size: 10 * time.Second, Late: CountLate},
+			inputs: []ProcessedData{at(0, 1), at(10, 2), at(3, 9), at(25, 3)},
+			want:   []WindowResult{{Count: 1, Sum: 1}, {Count: 1, Sum: 2, Late: 1}, {Count: 1, Sum: 3}},
+		},
+		{
+			name:   "gap between hopping windows is not late",
+			spec:   WindowSpec{Size: 5 * time.Second, Slide: 10 * time.Second, Late: CountLate},
+			inputs: []ProcessedData{at(0, 1), at(7, 2), at(12, 3)},
+			want:   []WindowResult{{Count: 1, Sum: 1}, {Count: 1, Sum: 3}},
+		},
+		{
+			name:   "late item after the last window",
+			spec:   WindowSpec{Size: 5 * time.Second, Slide: 10 * time.Second, Late: CountLate},
+			inputs: []ProcessedData{at(0, 1), at(7, 2), at(2, 9)},
+			want:   []WindowResult{{Count: 1, Sum: 1}, {Late: 1}},
+		},
+		{
+			name:   "late items dropped",
+			spec:   WindowSpec{Size: 10 * time.Second},
+			inputs: []ProcessedData{at(0, 1), at(10, 2), at(3, 9)},
+			want:   []WindowResult{{Count: 1, Sum: 1}, {Count: 1, Sum: 2}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := make(chan ProcessedData, len(tt.inputs))
+			for _, r := range tt.inputs {
+				in <- r
+			}
+			close(in)
+
+			out, err := Aggregate(context.Background(), in, tt.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []WindowResult
+			for r := range out {
+				got = append(got, WindowResult{Count: r.Count, Sum: r.Sum, Late: r.Late})
+			}
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d results %v, want %v", len(got), got, tt.want)
+			}
+			for i := range got {
+				if got[i].Count != tt.want[i].Count || got[i].Sum != tt.want[i].Sum || got[i].Late != tt.want[i].Late {
+					t.Errorf("result %d = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
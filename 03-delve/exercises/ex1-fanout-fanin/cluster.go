@@ -0,0 +1,508 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Message types exchanged between coordinator and remote workers. Every
+// message is one JSON object per line.
+const (
+	msgRegister   = "register"   // worker -> coordinator: hello
+	msgRegistered = "registered" // coordinator -> worker: assigned WorkerID
+	msgPull       = "pull"       // worker -> coordinator: ready for an item
+	msgItem       = "item"       // coordinator -> worker: process Item
+	msgWait       = "wait"       // coordinator -> worker: nothing to do yet
+	msgDone       = "done"       // coordinator -> worker: all items finished
+	msgResult     = "result"     // worker -> coordinator: Result or Error for ItemID
+	msgHeartbeat  = "heartbeat"  // worker -> coordinator: still alive
+)
+
+// DefaultHeartbeatTimeout is used by NewCoordinator when no timeout is
+// given. Workers heartbeat at a quarter of it unless told otherwise.
+const DefaultHeartbeatTimeout = 2 * time.Second
+
+// message is the wire format of the worker protocol
+type message struct {
+	Type     string         `json:"type"`
+	WorkerID int            `json:"worker_id,omitempty"`
+	ItemID   int            `json:"item_id,omitempty"`
+	Item     *DataItem      `json:"item,omitempty"`
+	Result   *ProcessedData `json:"result,omitempty"`
+	Error    string         `json:"error,omitempty"`
+}
+
+// conn wraps a connection with JSON line encoding. Writes may come from
+// several goroutines.
+type conn struct {
+	net.Conn
+	dec *json.Decoder
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func newConn(c net.Conn) *conn {
+	return &conn{
+		Conn: c,
+		dec:  json.NewDecoder(bufio.NewReader(c)),
+		enc:  json.NewEncoder(c),
+	}
+}
+
+func (c *conn) send(m message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.enc.Encode(m)
+}
+
+func (c *conn) receive() (message, error) {
+	var m message
+	err := c.dec.Decode(&m)
+	return m, err
+}
+
+// remoteWorker is the coordinator's view of one connected worker
+type remoteWorker struct {
+	id       int
+	conn     *conn
+	lastSeen time.Time
+	held     map[int]DataItem // items handed out and not yet returned
+}
+
+// Coordinator hands DataItems to remote worker processes over TCP or Unix
+// sockets and collects their results. Items held by a worker that
+// disconnects or stops heartbeating are put back in the queue.
+type Coordinator struct {
+	listener         net.Listener
+	heartbeatTimeout time.Duration
+
+	mu        sync.Mutex
+	nextID    int
+	workers   map[int]*remoteWorker
+	pending   []DataItem
+	completed map[int]bool
+	remaining int
+	results   []ProcessedData
+	finished  chan struct{}
+
+	processed  int
+	errors_cnt int
+	requeued   int
+}
+
+// NewCoordinator listens on addr. network is "tcp" or "unix". A worker that
+// has not been heard from for heartbeatTimeout is considered dead; zero or
+// less means DefaultHeartbeatTimeout.
+func NewCoordinator(network, addr string, heartbeatTimeout time.Duration) (*Coordinator, error) {
+	if heartbeatTimeout <= 0 {
+		heartbeatTimeout = DefaultHeartbeatTimeout
+	}
+
+	ln, err := net.Listen(network, addr)
+	if err != nil {
+		return nil, fmt.Errorf("coordinator: %w", err)
+	}
+
+	return &Coordinator{
+		listener:         ln,
+		heartbeatTimeout: heartbeatTimeout,
+		workers:          make(map[int]*remoteWorker),
+		completed:        make(map[int]bool),
+		finished:         make(chan struct{}),
+	}, nil
+}
+
+// Addr returns the address workers should connect to
+func (c *Coordinator) Addr() net.Addr {
+	return c.listener.Addr()
+}
+
+// Run distributes items to connected workers and returns their results once
+// every item has been processed, or ctx is cancelled.
+func (c *Coordinator) Run(ctx context.Context, items []DataItem) ([]ProcessedData, error) {
+	c.mu.Lock()
+	c.pending = append(c.pending, items...)
+	c.remaining = len(items)
+	if c.remaining == 0 {
+		close(c.finished)
+	}
+	c.mu.Unlock()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		c.accept()
+	}()
+	go func() {
+		defer wg.Done()
+		c.reap(ctx)
+	}()
+
+	var err error
+	select {
+	case <-c.finished:
+		log.Printf("Coordinator: all %d items finished\n", len(items))
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	// Closing the listener stops accept. Workers are told they are done on
+	// their next pull; any still connected after a grace period, or at
+	// once if ctx was cancelled, are cut off.
+	c.listener.Close()
+	grace := c.heartbeatTimeout
+	if err != nil {
+		grace = 0
+	}
+	cutoff := time.AfterFunc(grace, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		for _, w := range c.workers {
+			w.conn.Close()
+		}
+	})
+	wg.Wait()
+	cutoff.Stop()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.results, err
+}
+
+// GetStats returns processing statistics, including how many items were
+// re-queued after their worker died
+func (c *Coordinator) GetStats() (processed, errors, requeued int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.processed, c.errors_cnt, c.requeued
+}
+
+// accept serves worker connections until the listener is closed
+func (c *Coordinator) accept() {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		nc, err := c.listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("Coordinator: accept: %v\n", err)
+			}
+			return
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.serve(newConn(nc))
+		}()
+	}
+}
+
+// serve handles one worker connection
+func (c *Coordinator) serve(cn *conn) {
+	defer cn.Close()
+
+	m, err := cn.receive()
+	if err != nil || m.Type != msgRegister {
+		log.Printf("Coordinator: worker did not register: %v\n", err)
+		return
+	}
+
+	c.mu.Lock()
+	c.nextID++
+	w := &remoteWorker{
+		id:       c.nextID,
+		conn:     cn,
+		lastSeen: time.Now(),
+		held:     make(map[int]DataItem),
+	}
+	c.workers[w.id] = w
+	c.mu.Unlock()
+
+	log.Printf("Coordinator: worker %d registered from %s\n", w.id, cn.RemoteAddr())
+	defer c.drop(w, "disconnected")
+
+	if err := cn.send(message{Type: msgRegistered, WorkerID: w.id}); err != nil {
+		return
+	}
+
+	for {
+		m, err := cn.receive()
+		if err != nil {
+			return
+		}
+
+		c.mu.Lock()
+		w.lastSeen = time.Now()
+		c.mu.Unlock()
+
+		switch m.Type {
+		case msgHeartbeat:
+		case msgPull:
+			if err := cn.send(c.next(w)); err != nil {
+				return
+			}
+		case msgResult:
+			c.complete(w, m)
+		default:
+			log.Printf("Coordinator: worker %d sent unknown message %q\n", w.id, m.Type)
+		}
+	}
+}
+
+// next picks the reply to a pull from w. A re-queued item that its first
+// worker finished after all is dropped rather than handed out again.
+func (c *Coordinator) next(w *remoteWorker) message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for len(c.pending) > 0 && c.completed[c.pending[0].ID] {
+		c.pending = c.pending[1:]
+	}
+	if c.remaining == 0 {
+		return message{Type: msgDone}
+	}
+	if len(c.pending) == 0 {
+		return message{Type: msgWait}
+	}
+
+	item := c.pending[0]
+	c.pending = c.pending[1:]
+	w.held[item.ID] = item
+	return message{Type: msgItem, Item: &item}
+}
+
+// complete records a result from w. Results for items that were re-queued
+// and already finished elsewhere are ignored.
+func (c *Coordinator) complete(w *remoteWorker, m message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(w.held, m.ItemID)
+	if c.completed[m.ItemID] {
+		return
+	}
+	c.completed[m.ItemID] = true
+
+	if m.Error != "" {
+		log.Printf("Coordinator: %s\n", m.Error)
+		c.errors_cnt++
+	} else if m.Result != nil {
+		c.results = append(c.results, *m.Result)
+		c.processed++
+	}
+
+	c.remaining--
+	if c.remaining == 0 {
+		close(c.finished)
+	}
+}
+
+// drop removes w and re-queues every item it was holding
+func (c *Coordinator) drop(w *remoteWorker, reason string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.workers[w.id]; !ok {
+		return
+	}
+	delete(c.workers, w.id)
+	w.conn.Close()
+
+	for id, item := range w.held {
+		if !c.completed[id] {
+			c.pending = append(c.pending, item)
+			c.requeued++
+			log.Printf("Coordinator: re-queued item %d from worker %d\n", id, w.id)
+		}
+	}
+	log.Printf("Coordinator: worker %d %s\n", w.id, reason)
+}
+
+// reap drops workers that have stopped heartbeating
+func (c *Coordinator) reap(ctx context.Context) {
+	ticker := time.NewTicker(c.heartbeatTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-c.finished:
+			return
+		case <-ctx.Done():
+			return
+		}
+
+		var dead []*remoteWorker
+		c.mu.Lock()
+		for _, w := range c.workers {
+			if time.Since(w.lastSeen) > c.heartbeatTimeout {
+				dead = append(dead, w)
+			}
+		}
+		c.mu.Unlock()
+
+		for _, w := range dead {
+			c.drop(w, "missed heartbeats")
+		}
+	}
+}
+
+// RunWorker connects to a coordinator and processes items until the
+// coordinator reports that everything is done, the connection is lost, or ctx
+// is cancelled. A heartbeat of zero or less means a quarter of
+// DefaultHeartbeatTimeout.
+func RunWorker(ctx context.Context, network, addr string, heartbeat time.Duration) error {
+	if heartbeat <= 0 {
+		heartbeat = DefaultHeartbeatTimeout / 4
+	}
+
+	var d net.Dialer
+	nc, err := d.DialContext(ctx, network, addr)
+	if err != nil {
+		return fmt.Errorf("worker: %w", err)
+	}
+	cn := newConn(nc)
+	defer cn.Close()
+
+	// Unblock receive if ctx is cancelled
+	stop := context.AfterFunc(ctx, func() { cn.Close() })
+	defer stop()
+
+	if err := cn.send(message{Type: msgRegister}); err != nil {
+		return fmt.Errorf("worker: register: %w", err)
+	}
+	m, err := cn.receive()
+	if err != nil || m.Type != msgRegistered {
+		return fmt.Errorf("worker: registration rejected: %v", err)
+	}
+	id := m.WorkerID
+	log.Printf("Remote worker %d registered with %s\n", id, addr)
+
+	hbCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		ticker := time.NewTicker(heartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if cn.send(message{Type: msgHeartbeat, WorkerID: id}) != nil {
+					return
+				}
+			case <-hbCtx.Done():
+				return
+			}
+		}
+	}()
+
+	for {
+		if err := cn.send(message{Type: msgPull, WorkerID: id}); err != nil {
+			return fmt.Errorf("worker %d: %w", id, err)
+		}
+		m, err := cn.receive()
+		if err != nil {
+			return fmt.Errorf("worker %d: %w", id, err)
+		}
+
+		switch m.Type {
+		case msgDone:
+			log.Printf("Remote worker %d finished\n", id)
+			return nil
+		case msgWait:
+			if !sleep(ctx, 100*time.Millisecond) {
+				return ctx.Err()
+			}
+			continue
+		case msgItem:
+		default:
+			return fmt.Errorf("worker %d: unexpected message %q", id, m.Type)
+		}
+
+		item := *m.Item
+		reply := message{Type: msgResult, WorkerID: id, ItemID: item.ID}
+		if item.Value < 0 {
+			reply.Error = fmt.Sprintf("worker %d: negative value %d for item %d",
+				id, item.Value, item.ID)
+		} else {
+			result, ok := processItem(ctx, id, item, nil)
+			if !ok {
+				return ctx.Err()
+			}
+			reply.Result = &result
+		}
+
+		if err := cn.send(reply); err != nil {
+			return fmt.Errorf("worker %d: %w", id, err)
+		}
+	}
+}
+
+// runDistributed processes items on local worker subprocesses. When killOne
+// is set, the first worker is killed part way through to show its items
+// being re-queued.
+func runDistributed(network string, workers int, items []DataItem, killOne bool) error {
+	addr := "127.0.0.1:0"
+	if network == "unix" {
+		dir, err := os.MkdirTemp("", "fanout")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+		addr = filepath.Join(dir, "coordinator.sock")
+	}
+
+	coord, err := NewCoordinator(network, addr, DefaultHeartbeatTimeout)
+	if err != nil {
+		return err
+	}
+	log.Printf("Coordinator listening on %s %s\n", network, coord.Addr())
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	var procs []*exec.Cmd
+	for i := 0; i < workers; i++ {
+		cmd := exec.Command(exe, "-worker", coord.Addr().String(), "-network", network)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Start(); err != nil {
+			return fmt.Errorf("starting worker: %w", err)
+		}
+		procs = append(procs, cmd)
+	}
+
+	if killOne && len(procs) > 0 {
+		time.AfterFunc(time.Second, func() {
+			log.Printf("Killing worker process %d\n", procs[0].Process.Pid)
+			procs[0].Process.Kill()
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	results, err := coord.Run(ctx, items)
+
+	for _, cmd := range procs {
+		cmd.Wait()
+	}
+
+	processed, errors, requeued := coord.GetStats()
+	log.Printf("Distributed run collected %d results (processed=%d, errors=%d, requeued=%d)\n",
+		len(results), processed, errors, requeued)
+	return err
+}
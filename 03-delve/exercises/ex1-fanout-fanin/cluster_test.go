@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestNewCoordinatorDefaultsHeartbeatTimeout(t *testing.T) {
+	tests := []struct {
+		timeout time.Duration
+		want    time.Duration
+	}{
+		{0, DefaultHeartbeatTimeout},
+		{-time.Second, DefaultHeartbeatTimeout},
+		{time.Second, time.Second},
+	}
+	for _, tt := range tests {
+		c, err := NewCoordinator("tcp", "127.0.0.1:0", tt.timeout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.listener.Close()
+		if c.heartbeatTimeout != tt.want {
+			t.Errorf("NewCoordinator(%v) timeout = %v, want %v", tt.timeout, c.heartbeatTimeout, tt.want)
+		}
+	}
+}
+
+func TestCoordinatorSkipsCompletedRequeuedItems(t *testing.T) {
+	tests := []struct {
+		name      string
+		pending   []int
+		completed []int
+		want      []string // message types, and item IDs for items
+	}{
+		{"nothing completed", []int{1, 2}, nil, []string{"item 1", "item 2", "wait"}},
+		{"re-queued item finished", []int{1, 2}, []int{1}, []string{"item 2", "wait"}},
+		{"every item finished", []int{1, 2}, []int{1, 2}, []string{"done"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Coordinator{completed: make(map[int]bool), remaining: len(tt.pending)}
+			for _, id := range tt.pending {
+				c.pending = append(c.pending, DataItem{ID: id})
+			}
+			for _, id := range tt.completed {
+				c.completed[id] = true
+				c.remaining--
+			}
+
+			w := &remoteWorker{held: make(map[int]DataItem)}
+			var got []string
+			for range tt.want {
+				m := c.next(w)
+				if m.Type == msgItem {
+					got = append(got, fmt.Sprintf("item %d", m.Item.ID))
+				} else {
+					got = append(got, m.Type)
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("replies = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// The combinators in this file share the same rules:
+//   - every returned channel is closed exactly once, after the input channel
+//     is closed or ctx is cancelled, whichever happens first
+//   - every send also watches ctx, so a consumer that stops reading early
+//     only has to cancel ctx for all goroutines to exit
+
+// send delivers v on ch unless ctx is cancelled first
+func send[T any](ctx context.Context, ch chan<- T, v T) bool {
+	select {
+	case ch <- v:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// FanIn combines multiple channels into one. It is the context-aware
+// counterpart of Merge.
+func FanIn[T any](ctx context.Context, channels ...<-chan T) <-chan T {
+	out := make(chan T)
+
+	var wg sync.WaitGroup
+	for _, ch := range channels {
+		wg.Add(1)
+		go func(c <-chan T) {
+			defer wg.Done()
+			for {
+				select {
+				case v, ok := <-c:
+					if !ok {
+						return
+					}
+					if !send(ctx, out, v) {
+						return
+					}
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(ch)
+	}
+
+	// Only close once every forwarding goroutine has stopped sending
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+
+	return out
+}
+
+// Broadcast copies every value from in to n output channels. A value is only
+// read from in once all outputs have taken the previous one, so the slowest
+// consumer sets the pace.
+func Broadcast[T any](ctx context.Context, in <-chan T, n int) []<-chan T {
+	outs := make([]chan T, n)
+	result := make([]<-chan T, n)
+	for i := range outs {
+		outs[i] = make(chan T)
+		result[i] = outs[i]
+	}
+
+	go func() {
+		defer func() {
+			for _, out := range outs {
+				close(out)
+			}
+		}()
+
+		for {
+			select {
+			case v, ok := <-in:
+				if !ok {
+					return
+				}
+				for _, out := range outs {
+					if !send(ctx, out, v) {
+						return
+					}
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return result
+}
+
+// Tee splits in into two channels that each receive every value
+func Tee[T any](ctx context.Context, in <-chan T) (<-chan T, <-chan T) {
+	outs := Broadcast(ctx, in, 2)
+	return outs[0], outs[1]
+}
+
+// Partition routes each value to one of n channels by key. Values with the
+// same key always go to the same channel, in the order they arrived.
+// Negative keys are allowed. Partition panics if n < 1.
+func Partition[T any](ctx context.Context, in <-chan T, n int, key func(T) int) []<-chan T {
+	if n < 1 {
+		panic(fmt.Sprintf("Partition: n must be at least 1, got %d", n))
+	}
+
+	outs := make([]chan T, n)
+	result := make([]<-chan T, n)
+	for i := range outs {
+		outs[i] = make(chan T)
+		result[i] = outs[i]
+	}
+
+	go func() {
+		defer func() {
+			for _, out := range outs {
+				close(out)
+			}
+		}()
+
+		for {
+			select {
+			case v, ok := <-in:
+				if !ok {
+					return
+				}
+				idx := ((key(v) % n) + n) % n
+				if !send(ctx, outs[idx], v) {
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return result
+}
+
+// Batch groups values into slices of up to size items. A partial batch is
+// emitted once maxWait has passed since its first item, and when in closes.
+func Batch[T any](ctx context.Context, in <-chan T, size int, maxWait time.Duration) <-chan []T {
+	out := make(chan []T)
+
+	go func() {
+		defer close(out)
+
+		var batch []T
+		timer := time.NewTimer(maxWait)
+		timer.Stop()
+		defer timer.Stop()
+
+		flush := func() bool {
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			if len(batch) == 0 {
+				return true
+			}
+			b := batch
+			batch = nil
+			return send(ctx, out, b)
+		}
+
+		for {
+			select {
+			case v, ok := <-in:
+				if !ok {
+					flush()
+					return
+				}
+				if len(batch) == 0 {
+					timer.Reset(maxWait)
+				}
+				batch = append(batch, v)
+				if len(batch) >= size && !flush() {
+					return
+				}
+			case <-timer.C:
+				if !flush() {
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out
+}
+
+// Window emits count-based windows of size items, starting a new window
+// every step items. step == size gives tumbling windows, step < size gives
+// overlapping sliding windows. A trailing partial window is emitted when in
+// closes. Window panics if size or step is less than 1.
+func Window[T any](ctx context.Context, in <-chan T, size, step int) <-chan []T {
+	if size < 1 || step < 1 {
+		panic(fmt.Sprintf("Window: size and step must be at least 1, got %d and %d", size, step))
+	}
+
+	out := make(chan []T)
+
+	go func() {
+		defer close(out)
+
+		var buf []T
+		fresh := 0 // items not yet emitted in any window
+		skip := 0  // items to drop before the next window starts (step > size)
+		for {
+			select {
+			case v, ok := <-in:
+				if !ok {
+					if fresh > 0 {
+						send(ctx, out, buf)
+					}
+					return
+				}
+				if skip > 0 {
+					skip--
+					continue
+				}
+				buf = append(buf, v)
+				fresh++
+				if len(buf) < size {
+					continue
+				}
+
+				window := make([]T, size)
+				copy(window, buf)
+				if !send(ctx, out, window) {
+					return
+				}
+				fresh = 0
+
+				if step >= size {
+					buf = buf[:0]
+					skip = step - size
+				} else {
+					buf = append(buf[:0], buf[step:]...)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out
+}
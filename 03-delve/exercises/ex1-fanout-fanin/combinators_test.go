@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// source returns a closed channel holding values
+func source(values ...int) <-chan int {
+	ch := make(chan int, len(values))
+	for _, v := range values {
+		ch <- v
+	}
+	close(ch)
+	return ch
+}
+
+func TestPartition(t *testing.T) {
+	tests := []struct {
+		name   string
+		n      int
+		values []int
+		want   [][]int
+	}{
+		{"one partition", 1, []int{3, -1, 4}, [][]int{{3, -1, 4}}},
+		{"positive keys", 3, []int{0, 1, 2, 3, 4, 5}, [][]int{{0, 3}, {1, 4}, {2, 5}}},
+		{"negative keys", 3, []int{-1, -2, -3, -4}, [][]int{{-3}, {-2}, {-1, -4}}},
+		{"empty input", 2, nil, [][]int{nil, nil}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outs := Partition(context.Background(), source(tt.values...), tt.n, func(v int) int { return v })
+
+			got := make([][]int, len(outs))
+			var wg sync.WaitGroup
+			for i, out := range outs {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for v := range out {
+						got[i] = append(got[i], v)
+					}
+				}()
+			}
+			wg.Wait()
+
+			if !slices.EqualFunc(got, tt.want, slices.Equal) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWindow(t *testing.T) {
+	tests := []struct {
+		name       string
+		size, step int
+		values     []int
+		want       [][]int
+	}{
+		{"tumbling", 2, 2, []int{1, 2, 3, 4}, [][]int{{1, 2}, {3, 4}}},
+		{"tumbling with partial", 2, 2, []int{1, 2, 3}, [][]int{{1, 2}, {3}}},
+		{"sliding", 3, 1, []int{1, 2, 3, 4}, [][]int{{1, 2, 3}, {2, 3, 4}}},
+		{"hopping", 1, 2, []int{1, 2, 3, 4, 5}, [][]int{{1}, {3}, {5}}},
+		{"shorter than size", 5, 1, []int{1, 2}, [][]int{{1, 2}}},
+		{"empty input", 2, 1, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got [][]int
+			for w := range Window(context.Background(), source(tt.values...), tt.size, tt.step) {
+				got = append(got, w)
+			}
+			if !slices.EqualFunc(got, tt.want, slices.Equal) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCombinatorsRejectBadSizes(t *testing.T) {
+	tests := []struct {
+		name string
+		call func()
+	}{
+		{"Partition n=0", func() { Partition(context.Background(), source(), 0, func(v int) int { return v }) }},
+		{"Partition n<0", func() { Partition(context.Background(), source(), -2, func(v int) int { return v }) }},
+		{"Window size=0", func() { Window(context.Background(), source(), 0, 1) }},
+		{"Window step=0", func() { Window(context.Background(), source(), 2, 0) }},
+		{"Window step<0", func() { Window(context.Background(), source(), 2, -1) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("did not panic")
+				}
+			}()
+			tt.call()
+		})
+	}
+}
@@ -1,3 +1,8 @@
 module github.com/derekparker/gophercon-2025/03-delve/exercises/ex2-fanout-fanin
 
-go 1.21
+go 1.23
+
+// The supervisor package is shared with the Delve demo
+require github.com/derekparker/gophercon-2025/03-delve/demo v0.0.0
+
+replace github.com/derekparker/gophercon-2025/03-delve/demo => ../../demo
@@ -1,17 +1,29 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"log"
 	"math/rand"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
 	"sync"
+	"syscall"
 	"time"
+
+	"github.com/derekparker/gophercon-2025/03-delve/demo/supervisor"
 )
 
 // DataItem represents input data to process
 type DataItem struct {
 	ID    int
 	Value int
+	// Timestamp is when the item was produced. Zero means it is stamped
+	// with the time processing finishes.
+	Timestamp time.Time
 }
 
 // ProcessedData represents the result of processing
@@ -20,10 +32,15 @@ type ProcessedData struct {
 	Original int
 	Result   int
 	WorkerID int
+	// Timestamp is the item's event time, used for windowed aggregation
+	Timestamp time.Time
 }
 
 // DataProcessor implements a fan-out/fan-in processing pipeline
 type DataProcessor struct {
+	// Transform computes each item's result. Nil squares the value.
+	Transform func(value int) int
+
 	numWorkers int
 	input      chan DataItem
 	output     chan ProcessedData
@@ -32,6 +49,28 @@ type DataProcessor struct {
 	mu         sync.Mutex
 	processed  int
 	errors_cnt int
+
+	// Stuck-item detection, see EnableSpeculation
+	speculation     *SpeculationPolicy
+	retries         chan DataItem
+	inflight        map[int]*inflightItem
+	completed       map[int]bool
+	latencies       []time.Duration
+	idle            int
+	speculated      int
+	speculativeWins int
+	inputDone       bool
+	drained         chan struct{}
+	drainOnce       sync.Once
+	done            chan struct{}
+	doneOnce        sync.Once
+
+	// Panic recovery, see Supervise
+	supervisor *supervisor.Supervisor
+
+	// Result output, see AttachSink
+	sink     *BufferedSink
+	sinkOnce sync.Once
 }
 
 // NewDataProcessor creates a new processor
@@ -49,9 +88,17 @@ func (dp *DataProcessor) Start() {
 	log.Printf("Starting processor with %d workers\n", dp.numWorkers)
 
 	// Fan-out: start worker goroutines
-	for i := 0; i < dp.numWorkers; i++ {
-		dp.wg.Add(1)
-		go dp.worker(i + 1)
+	if dp.supervisor != nil {
+		dp.startSupervised()
+	} else {
+		for i := 0; i < dp.numWorkers; i++ {
+			dp.wg.Add(1)
+			go dp.worker(i + 1)
+		}
+	}
+
+	if dp.speculation != nil {
+		go dp.speculate()
 	}
 
 	// BUG: No goroutine to handle errors channel
@@ -62,10 +109,54 @@ func (dp *DataProcessor) Start() {
 func (dp *DataProcessor) worker(id int) {
 	defer dp.wg.Done() // BUG: This might not always be called
 
+	dp.work(context.Background(), id, func(any) {})
+}
+
+// work is the worker loop. It returns when the input is drained or ctx is
+// cancelled, and calls track with every item before processing it.
+func (dp *DataProcessor) work(ctx context.Context, id int, track func(item any)) {
 	log.Printf("Worker %d started\n", id)
 
-	for item := range dp.input {
-		log.Printf("Worker %d processing item %d\n", id, item.ID)
+	input := dp.input
+	for {
+		var item DataItem
+		speculative := false
+
+		dp.setIdle(true)
+		select {
+		case item = <-dp.retries:
+			speculative = true
+		case next, ok := <-input:
+			if !ok {
+				if dp.speculation == nil {
+					log.Printf("Worker %d shutting down\n", id)
+					return
+				}
+				// Stay around to pick up duplicate attempts until
+				// every in-flight item has finished.
+				input = nil
+				dp.setIdle(false)
+				dp.inputClosed()
+				continue
+			}
+			item = next
+		case <-dp.drained:
+			dp.setIdle(false)
+			log.Printf("Worker %d shutting down\n", id)
+			return
+		case <-ctx.Done():
+			dp.setIdle(false)
+			log.Printf("Worker %d stopped\n", id)
+			return
+		}
+		dp.setIdle(false)
+		track(item)
+
+		if speculative {
+			log.Printf("Worker %d speculatively processing item %d\n", id, item.ID)
+		} else {
+			log.Printf("Worker %d processing item %d\n", id, item.ID)
+		}
 
 		// Simulate processing with potential errors
 		if item.Value < 0 {
@@ -79,24 +170,22 @@ func (dp *DataProcessor) worker(id int) {
 			continue
 		}
 
-		// Simulate processing delay
-		processingTime := time.Duration(rand.Intn(500)+100) * time.Millisecond
-		time.Sleep(processingTime)
-
-		// Simulate occasional worker getting stuck
-		if rand.Intn(20) == 0 {
-			log.Printf("Worker %d stuck on item %d!\n", id, item.ID)
-			time.Sleep(5 * time.Second)
-			// BUG: Worker doesn't check for cancellation
+		// An item already started is finished even if ctx is cancelled,
+		// so stopping a worker never loses it
+		itemCtx, ok := dp.begin(item)
+		if !ok {
+			log.Printf("Worker %d skipping item %d, already completed\n", id, item.ID)
+			continue
 		}
 
-		result := ProcessedData{
-			ItemID:   item.ID,
-			Original: item.Value,
-			Result:   item.Value * item.Value, // Square the value
-			WorkerID: id,
+		result, ok := processItem(itemCtx, id, item, dp.Transform)
+		if !ok || !dp.finish(item.ID, speculative) {
+			log.Printf("Worker %d abandoning item %d, another attempt won\n", id, item.ID)
+			continue
 		}
 
+		dp.record(result)
+
 		// BUG: This will block if no one is reading
 		dp.output <- result
 
@@ -106,8 +195,55 @@ func (dp *DataProcessor) worker(id int) {
 
 		log.Printf("Worker %d completed item %d\n", id, item.ID)
 	}
+}
+
+// processItem runs transform on a single item, giving up early if ctx is
+// cancelled. A nil transform squares the value.
+func processItem(ctx context.Context, id int, item DataItem, transform func(int) int) (ProcessedData, bool) {
+	// Simulate processing delay
+	processingTime := time.Duration(rand.Intn(500)+100) * time.Millisecond
+	if !sleep(ctx, processingTime) {
+		return ProcessedData{}, false
+	}
+
+	// Simulate occasional worker getting stuck
+	if rand.Intn(20) == 0 {
+		log.Printf("Worker %d stuck on item %d!\n", id, item.ID)
+		if !sleep(ctx, 5*time.Second) {
+			return ProcessedData{}, false
+		}
+	}
+
+	result := item.Value * item.Value // Square the value
+	if transform != nil {
+		result = transform(item.Value)
+	}
 
-	log.Printf("Worker %d shutting down\n", id)
+	ts := item.Timestamp
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+
+	return ProcessedData{
+		ItemID:    item.ID,
+		Original:  item.Value,
+		Result:    result,
+		WorkerID:  id,
+		Timestamp: ts,
+	}, true
+}
+
+// sleep pauses for d and reports whether it finished before ctx was cancelled
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
 }
 
 // Process sends data items for processing
@@ -156,6 +292,8 @@ func (dp *DataProcessor) CollectResults() []ProcessedData {
 func (dp *DataProcessor) Wait() {
 	// BUG: This will hang if workers are blocked on sending
 	dp.wg.Wait()
+	dp.stopSpeculation()
+	dp.CloseSink()
 	// BUG: Should close output channel here
 	log.Println("All workers completed")
 }
@@ -195,34 +333,17 @@ func Merge(channels ...<-chan ProcessedData) <-chan ProcessedData {
 
 // SimulateMultiStage demonstrates a multi-stage pipeline with fan-out/fan-in
 func SimulateMultiStage(items []DataItem) {
-	// Stage 1: Split data into chunks for parallel processing
+	// Stage 1: Split data into partitions for parallel processing. Each
+	// partition gets 2 workers, and idle workers steal from busy partitions.
 	numChunks := 3
-	chunkSize := len(items) / numChunks
-
-	var resultChannels []<-chan ProcessedData
-
-	for i := 0; i < numChunks; i++ {
-		start := i * chunkSize
-		end := start + chunkSize
-		if i == numChunks-1 {
-			end = len(items)
-		}
-
-		chunk := items[start:end]
-		processor := NewDataProcessor(2) // 2 workers per chunk
-		processor.Start()
-
-		// Process chunk in parallel
-		go func(p *DataProcessor, data []DataItem) {
-			p.Process(data)
-			// BUG: Never calls Wait() or closes channels
-		}(processor, chunk)
-
-		resultChannels = append(resultChannels, processor.output)
+	scheduler, err := NewStealingScheduler(numChunks, 2)
+	if err != nil {
+		log.Println(err)
+		return
 	}
 
-	// Fan-in: merge all result channels
-	merged := Merge(resultChannels...)
+	// Fan-in: every worker sends to the same output channel
+	merged := scheduler.Run(items)
 
 	// Collect all results
 	var allResults []ProcessedData
@@ -244,7 +365,26 @@ func SimulateMultiStage(items []DataItem) {
 		}
 	}
 
-	log.Printf("Multi-stage processing collected %d results\n", len(allResults))
+	stolen, errors := scheduler.Stats()
+	log.Printf("Multi-stage processing collected %d results (%d stolen, %d errors)\n",
+		len(allResults), stolen, errors)
+}
+
+// openSink picks a sink for path by its extension
+func openSink(path string) (Sink, error) {
+	opts := RotateOptions{MaxBytes: 1 << 20, SyncEvery: 10, SyncInterval: time.Second}
+	if filepath.Ext(path) == ".csv" {
+		return NewCSVSink(path, opts)
+	}
+	return NewJSONLSink(path, opts)
+}
+
+func sum(values []int) int {
+	total := 0
+	for _, v := range values {
+		total += v
+	}
+	return total
 }
 
 func generateData(count int) []DataItem {
@@ -259,15 +399,58 @@ func generateData(count int) []DataItem {
 	return items
 }
 
+var (
+	workerAddr  = flag.String("worker", "", "run as a remote worker for the coordinator at this address")
+	distributed = flag.Int("distributed", 0, "process items on this many local worker processes and exit")
+	network     = flag.String("network", "tcp", "network for -worker and -distributed: tcp or unix")
+	killWorker  = flag.Bool("kill-worker", false, "with -distributed, kill one worker part way through")
+	sinkPath    = flag.String("sink", "", "write Test 1 results to this file; .csv writes CSV, anything else JSON lines")
+)
+
 func main() {
+	flag.Parse()
 	log.SetFlags(log.Lmicroseconds)
 	rand.Seed(time.Now().UnixNano())
 
+	if *workerAddr != "" {
+		if err := RunWorker(context.Background(), *network, *workerAddr, 500*time.Millisecond); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *distributed > 0 {
+		if err := runDistributed(*network, *distributed, generateData(30), *killWorker); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// Interrupting the demo stops it cleanly, flushing any -sink output
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	log.Println("=== Starting Fan-out/Fan-in Processing Demo ===")
 
 	// Test 1: Basic fan-out/fan-in
 	log.Println("\n--- Test 1: Basic Processing ---")
 	processor := NewDataProcessor(3)
+	processor.EnableSpeculation(SpeculationPolicy{
+		Threshold:  2 * time.Second,
+		Percentile: 0.99,
+	})
+	if *sinkPath != "" {
+		sink, err := openSink(*sinkPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		processor.AttachSink(ctx, sink, 16)
+	}
+	go func() {
+		<-ctx.Done()
+		processor.CloseSink()
+		os.Exit(130)
+	}()
 	processor.Start()
 
 	items := generateData(10)
@@ -297,11 +480,51 @@ func main() {
 		log.Printf("Stats: processed=%d, errors=%d\n", processed, errors)
 	}
 
+	launched, won := processor.GetSpeculationStats()
+	log.Printf("Speculation: launched=%d, won=%d\n", launched, won)
+
 	// Test 2: Multi-stage pipeline
 	log.Println("\n--- Test 2: Multi-Stage Pipeline ---")
 	moreItems := generateData(15)
 	SimulateMultiStage(moreItems)
 
+	// Test 3: Streaming results, stopping early
+	log.Println("\n--- Test 3: Streaming Results ---")
+	streamer := NewDataProcessor(3)
+	streamed := 0
+	for result, err := range streamer.Stream(context.Background(), slices.Values(generateData(20))) {
+		if err != nil {
+			log.Printf("Stream error: %v\n", err)
+			continue
+		}
+		log.Printf("Streamed result for item %d from worker %d\n", result.ItemID, result.WorkerID)
+		if streamed++; streamed == 5 {
+			log.Println("Stopping stream early")
+			break
+		}
+	}
+
+	// Test 4: MapReduce over the processed values
+	log.Println("\n--- Test 4: MapReduce ---")
+	buckets, err := MapReduce(context.Background(), NewDataProcessor(3), MapReduceConfig{Reducers: 2, Seed: 42},
+		generateData(30),
+		func(r ProcessedData) []KeyValue[string, int] {
+			bucket := "small"
+			if r.Result >= 1000 {
+				bucket = "large"
+			}
+			return []KeyValue[string, int]{{bucket, r.Result}}
+		},
+		func(_ string, values []int) int { return sum(values) },
+		func(_ string, values []int) int { return sum(values) },
+	)
+	if err != nil {
+		log.Printf("MapReduce skipped items: %v\n", err)
+	}
+	for _, b := range buckets {
+		log.Printf("MapReduce %s total: %d\n", b.Key, b.Value)
+	}
+
 	// Keep program alive for debugging
 	log.Println("\nProgram finished but keeping alive for debugging...")
 	log.Println("Use Delve to inspect goroutine state")
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"sync"
+)
+
+// KeyValue is one pair emitted by a map function
+type KeyValue[K cmp.Ordered, V any] struct {
+	Key   K
+	Value V
+}
+
+// tasksPerMapper is how many map tasks the input is cut into per mapper
+const tasksPerMapper = 8
+
+// MapReduceConfig configures the reduce side of a MapReduce run
+type MapReduceConfig struct {
+	Reducers int
+	// Seed picks the key-to-reducer assignment. The same input and seed
+	// always produce the same output.
+	Seed uint64
+}
+
+// MapReduce runs items through the processor's workers, maps each result,
+// optionally combines each task's output locally, shuffles the pairs by key
+// to reducers and reduces every key to one result. Results come back sorted
+// by key.
+//
+// The map phase runs on as many goroutines as the processor has workers.
+// Each handles an item the way a Stream worker does, with Transform and the
+// simulated delays, and passes the result to mapFn. Items that fail, such as
+// negative values, are counted in the processor's stats and left out; the
+// returned error joins their errors, in input order, alongside the results
+// of the rest.
+//
+// The input is cut into many small tasks that idle workers pull from a
+// queue, so a slow region of the input does not hold up one worker while the
+// others wait. Values reach combine and reduce in input order, so the output
+// is deterministic even when the functions are not commutative, as long as
+// Transform and mapFn are deterministic. mapFn should not depend on
+// WorkerID, or on Timestamp for items without one.
+func MapReduce[K cmp.Ordered, V, R any](
+	ctx context.Context,
+	dp *DataProcessor,
+	cfg MapReduceConfig,
+	items []DataItem,
+	mapFn func(ProcessedData) []KeyValue[K, V],
+	combine func(K, []V) V, // optional, may be nil
+	reduce func(K, []V) R,
+) ([]KeyValue[K, R], error) {
+	mappers := dp.numWorkers
+	if mappers <= 0 || cfg.Reducers <= 0 {
+		return nil, fmt.Errorf("mapreduce: need at least one worker and reducer, got %d and %d",
+			mappers, cfg.Reducers)
+	}
+
+	// Map: the input is cut into contiguous tasks, several per worker, that
+	// workers take from a queue. Each task is combined on its own, so the
+	// combiner sees values in input order whichever worker runs it.
+	type mapped struct {
+		key   K
+		value V
+		order int // position of the producing item, for stable ordering
+		seq   int // position within the item's output
+	}
+	chunk := max((len(items)+mappers*tasksPerMapper-1)/(mappers*tasksPerMapper), 1)
+	tasks := make(chan int, (len(items)+chunk-1)/chunk)
+	for start := 0; start < len(items); start += chunk {
+		tasks <- start
+	}
+	close(tasks)
+	shards := make([][]mapped, cap(tasks))
+	failed := make([]error, len(items)) // by item, set by the worker that ran it
+
+	var wg sync.WaitGroup
+	for m := 0; m < mappers; m++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+
+			for start := range tasks {
+				end := min(start+chunk, len(items))
+				var out []mapped
+				for i := start; i < end; i++ {
+					r, ok := dp.process(ctx, id, items[i])
+					if !ok {
+						return
+					}
+					if r.err != nil {
+						failed[i] = r.err
+						continue
+					}
+					for j, kv := range mapFn(r.result) {
+						out = append(out, mapped{kv.Key, kv.Value, i, j})
+					}
+				}
+
+				if combine != nil {
+					out = combineLocal(out, func(m mapped) K { return m.key }, func(group []mapped) mapped {
+						values := make([]V, len(group))
+						for i, g := range group {
+							values[i] = g.value
+						}
+						first := group[0]
+						return mapped{first.key, combine(first.key, values), first.order, first.seq}
+					})
+				}
+				shards[start/chunk] = out
+			}
+		}(m + 1)
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// Shuffle: route every pair to the reducer that owns its key
+	partitions := make([][]mapped, cfg.Reducers)
+	for _, shard := range shards {
+		for _, kv := range shard {
+			r := reducerFor(kv.key, cfg.Seed, cfg.Reducers)
+			partitions[r] = append(partitions[r], kv)
+		}
+	}
+
+	// Reduce: every reducer handles its keys in sorted order
+	reduced := make([][]KeyValue[K, R], cfg.Reducers)
+	for r := 0; r < cfg.Reducers; r++ {
+		wg.Add(1)
+		go func(r int) {
+			defer wg.Done()
+
+			part := partitions[r]
+			slices.SortStableFunc(part, func(a, b mapped) int {
+				return cmp.Or(cmp.Compare(a.key, b.key), cmp.Compare(a.order, b.order), cmp.Compare(a.seq, b.seq))
+			})
+
+			for i := 0; i < len(part); {
+				if ctx.Err() != nil {
+					return
+				}
+				j := i
+				var values []V
+				for ; j < len(part) && part[j].key == part[i].key; j++ {
+					values = append(values, part[j].value)
+				}
+				reduced[r] = append(reduced[r], KeyValue[K, R]{part[i].key, reduce(part[i].key, values)})
+				i = j
+			}
+		}(r)
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var results []KeyValue[K, R]
+	for _, r := range reduced {
+		results = append(results, r...)
+	}
+	slices.SortFunc(results, func(a, b KeyValue[K, R]) int { return cmp.Compare(a.Key, b.Key) })
+	return results, errors.Join(failed...)
+}
+
+// combineLocal merges values that share a key into one, keeping the groups in
+// order of first appearance
+func combineLocal[T any, K comparable](in []T, key func(T) K, merge func([]T) T) []T {
+	groups := make(map[K][]T)
+	var order []K
+	for _, v := range in {
+		k := key(v)
+		if _, ok := groups[k]; !ok {
+			order = append(order, k)
+		}
+		groups[k] = append(groups[k], v)
+	}
+
+	out := make([]T, 0, len(order))
+	for _, k := range order {
+		out = append(out, merge(groups[k]))
+	}
+	return out
+}
+
+// reducerFor picks the reducer for key. It hashes the key's printed form so the
+// assignment is the same on every run with the same seed.
+func reducerFor[K cmp.Ordered](key K, seed uint64, reducers int) int {
+	h := fnv.New64a()
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], seed)
+	h.Write(buf[:])
+	fmt.Fprint(h, key)
+	return int(h.Sum64() % uint64(reducers))
+}
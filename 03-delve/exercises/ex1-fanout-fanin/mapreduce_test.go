@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestMapReduceSameOutputForAnyWorkerCount(t *testing.T) {
+	values := []int{3, -1, 5, 8, 2, -4, 7, 1}
+	items := make([]DataItem, len(values))
+	for i, v := range values {
+		items[i] = DataItem{ID: i + 1, Value: v}
+	}
+
+	// Joining is not commutative, so any change in the order values reach
+	// the combiner or reducer shows up in the output
+	join := func(_ int, values []string) string { return strings.Join(values, ",") }
+	want := []KeyValue[int, string]{
+		{0, "10"},      // item 3; item 6 fails
+		{1, "6,16,14"}, // items 1, 4 and 7
+		{2, "4,2"},     // items 5 and 8; item 2 fails
+	}
+
+	for _, workers := range []int{1, 2, 3, 8} {
+		t.Run(fmt.Sprintf("workers=%d", workers), func(t *testing.T) {
+			t.Parallel()
+			dp := NewDataProcessor(workers)
+			dp.Transform = func(v int) int { return v * 2 }
+
+			got, err := MapReduce(context.Background(), dp, MapReduceConfig{Reducers: 2, Seed: 7}, items,
+				func(r ProcessedData) []KeyValue[int, string] {
+					return []KeyValue[int, string]{{r.ItemID % 3, fmt.Sprint(r.Result)}}
+				},
+				join, join,
+			)
+			if err == nil {
+				t.Error("no error for the negative items")
+			}
+			if !slices.Equal(got, want) {
+				t.Errorf("results = %v, want %v", got, want)
+			}
+			if processed, failed := dp.GetStats(); processed != 6 || failed != 2 {
+				t.Errorf("stats = %d processed, %d errors; want 6 and 2", processed, failed)
+			}
+		})
+	}
+}
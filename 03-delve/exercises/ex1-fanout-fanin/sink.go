@@ -0,0 +1,423 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrSinkClosed is returned when writing to or flushing a closed sink
+var ErrSinkClosed = errors.New("sink closed")
+
+// Sink receives every result a DataProcessor produces
+type Sink interface {
+	Write(ProcessedData) error
+	// Flush pushes anything buffered down to durable storage
+	Flush() error
+	// Close flushes and releases the sink
+	Close() error
+}
+
+// RotateOptions controls file rotation and fsync batching
+type RotateOptions struct {
+	// MaxBytes starts a new file once the current one would grow past this
+	// size. Zero disables rotation.
+	MaxBytes int64
+	// SyncEvery fsyncs after this many records. Zero leaves it to
+	// SyncInterval and Flush.
+	SyncEvery int
+	// SyncInterval fsyncs records at most this long after they are
+	// written, even if nothing else is written. Zero disables the
+	// time-based sync.
+	SyncInterval time.Duration
+}
+
+// RotatingFile writes records to path, moving full files aside as path.1,
+// path.2 and so on. A record is never split across two files.
+type RotatingFile struct {
+	path   string
+	opts   RotateOptions
+	header []byte // written at the top of every new file
+
+	mu        sync.Mutex // the sync timer runs on its own goroutine
+	f         *os.File
+	w         *bufio.Writer
+	size      int64
+	rotated   int
+	unsynced  int
+	syncTimer *time.Timer // pending SyncInterval sync, nil if none
+	closed    bool
+}
+
+// OpenRotatingFile creates or truncates path. header, if not nil, is written
+// at the start of every file.
+func OpenRotatingFile(path string, header []byte, opts RotateOptions) (*RotatingFile, error) {
+	r := &RotatingFile{path: path, opts: opts, header: header}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *RotatingFile) open() error {
+	f, err := os.Create(r.path)
+	if err != nil {
+		return err
+	}
+	r.f = f
+	r.w = bufio.NewWriter(f)
+	r.size = 0
+
+	if len(r.header) > 0 {
+		n, err := r.w.Write(r.header)
+		r.size += int64(n)
+		return err
+	}
+	return nil
+}
+
+// rotate syncs and closes the current file, moves it aside and opens a new one
+func (r *RotatingFile) rotate() error {
+	if err := r.sync(); err != nil {
+		return err
+	}
+	if err := r.f.Close(); err != nil {
+		return err
+	}
+
+	r.rotated++
+	if err := os.Rename(r.path, r.path+"."+strconv.Itoa(r.rotated)); err != nil {
+		return err
+	}
+	return r.open()
+}
+
+// WriteRecord appends one complete record, rotating first if it would not
+// fit in the current file
+func (r *RotatingFile) WriteRecord(p []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return ErrSinkClosed
+	}
+
+	if r.opts.MaxBytes > 0 && r.size+int64(len(p)) > r.opts.MaxBytes && r.size > int64(len(r.header)) {
+		if err := r.rotate(); err != nil {
+			return fmt.Errorf("rotating %s: %w", r.path, err)
+		}
+	}
+
+	n, err := r.w.Write(p)
+	r.size += int64(n)
+	if err != nil {
+		return err
+	}
+
+	r.unsynced++
+	if r.opts.SyncEvery > 0 && r.unsynced >= r.opts.SyncEvery {
+		return r.sync()
+	}
+	if r.opts.SyncInterval > 0 && r.syncTimer == nil {
+		r.syncTimer = time.AfterFunc(r.opts.SyncInterval, r.syncLater)
+	}
+	return nil
+}
+
+// syncLater is the SyncInterval timer
+func (r *RotatingFile) syncLater() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.syncTimer = nil
+	if r.closed || r.unsynced == 0 {
+		return
+	}
+	if err := r.sync(); err != nil {
+		log.Printf("Syncing %s: %v\n", r.path, err)
+	}
+}
+
+// Sync flushes buffered records and fsyncs the file
+func (r *RotatingFile) Sync() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return ErrSinkClosed
+	}
+	return r.sync()
+}
+
+// sync is Sync for callers holding r.mu
+func (r *RotatingFile) sync() error {
+	if r.syncTimer != nil {
+		r.syncTimer.Stop()
+		r.syncTimer = nil
+	}
+	if err := r.w.Flush(); err != nil {
+		return err
+	}
+	if err := r.f.Sync(); err != nil {
+		return err
+	}
+	r.unsynced = 0
+	return nil
+}
+
+// Close syncs and closes the current file
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return ErrSinkClosed
+	}
+	r.closed = true
+
+	err := r.sync()
+	if cerr := r.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// JSONLSink writes one JSON object per result per line
+type JSONLSink struct {
+	file *RotatingFile
+}
+
+// NewJSONLSink creates a JSON lines sink writing to path
+func NewJSONLSink(path string, opts RotateOptions) (*JSONLSink, error) {
+	f, err := OpenRotatingFile(path, nil, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &JSONLSink{file: f}, nil
+}
+
+func (s *JSONLSink) Write(r ProcessedData) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return s.file.WriteRecord(append(line, '\n'))
+}
+
+func (s *JSONLSink) Flush() error { return s.file.Sync() }
+func (s *JSONLSink) Close() error { return s.file.Close() }
+
+// csvHeader names the columns written by CSVSink
+var csvHeader = []string{"item_id", "original", "result", "worker_id", "timestamp"}
+
+// CSVSink writes results as CSV rows, repeating the header in every file.
+// It is safe for concurrent use.
+type CSVSink struct {
+	file *RotatingFile
+
+	mu  sync.Mutex // guards the encoder, which reuses buf for every row
+	buf bytes.Buffer
+	w   *csv.Writer
+}
+
+// NewCSVSink creates a CSV sink writing to path
+func NewCSVSink(path string, opts RotateOptions) (*CSVSink, error) {
+	s := &CSVSink{}
+	s.w = csv.NewWriter(&s.buf)
+
+	header, err := s.encode(csvHeader)
+	if err != nil {
+		return nil, err
+	}
+	s.file, err = OpenRotatingFile(path, header, opts)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// encode formats a single CSV row
+func (s *CSVSink) encode(row []string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.buf.Reset()
+	s.w.Write(row)
+	s.w.Flush()
+	if err := s.w.Error(); err != nil {
+		return nil, err
+	}
+	return bytes.Clone(s.buf.Bytes()), nil
+}
+
+func (s *CSVSink) Write(r ProcessedData) error {
+	row, err := s.encode([]string{
+		strconv.Itoa(r.ItemID),
+		strconv.Itoa(r.Original),
+		strconv.Itoa(r.Result),
+		strconv.Itoa(r.WorkerID),
+		r.Timestamp.Format(time.RFC3339Nano),
+	})
+	if err != nil {
+		return err
+	}
+	return s.file.WriteRecord(row)
+}
+
+func (s *CSVSink) Flush() error { return s.file.Sync() }
+func (s *CSVSink) Close() error { return s.file.Close() }
+
+// BufferedSink hands results to another sink from a background goroutine.
+// The buffer is bounded: once it is full, Write blocks until the underlying
+// sink catches up, pushing back on whoever is producing results.
+type BufferedSink struct {
+	sink    Sink
+	queue   chan ProcessedData
+	flushes chan chan error
+	done    chan struct{}
+
+	// Held for reading while sending to queue or flushes, and for writing
+	// to close them, so nothing is sent once the sink is closed
+	closeMu sync.RWMutex
+	closed  bool
+
+	mu  sync.Mutex
+	err error // first error from the underlying sink
+}
+
+// NewBufferedSink wraps sink with a buffer of size results
+func NewBufferedSink(sink Sink, size int) *BufferedSink {
+	b := &BufferedSink{
+		sink:    sink,
+		queue:   make(chan ProcessedData, size),
+		flushes: make(chan chan error),
+		done:    make(chan struct{}),
+	}
+	go b.run()
+	return b
+}
+
+func (b *BufferedSink) run() {
+	defer close(b.done)
+
+	for {
+		select {
+		case r, ok := <-b.queue:
+			if !ok {
+				b.setErr(b.sink.Close())
+				return
+			}
+			b.setErr(b.sink.Write(r))
+		case reply := <-b.flushes:
+			// Write everything queued before the flush was requested
+			for n := len(b.queue); n > 0; n-- {
+				b.setErr(b.sink.Write(<-b.queue))
+			}
+			reply <- b.sink.Flush()
+		}
+	}
+}
+
+func (b *BufferedSink) setErr(err error) {
+	if err == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err == nil {
+		b.err = err
+	}
+}
+
+func (b *BufferedSink) getErr() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.err
+}
+
+// Write queues r, blocking while the buffer is full. It returns the first
+// error the underlying sink has reported, if any, or ErrSinkClosed once the
+// sink is closed.
+func (b *BufferedSink) Write(r ProcessedData) error {
+	b.closeMu.RLock()
+	defer b.closeMu.RUnlock()
+	if b.closed {
+		return ErrSinkClosed
+	}
+	if err := b.getErr(); err != nil {
+		return err
+	}
+	b.queue <- r
+	return nil
+}
+
+// Flush waits for everything queued so far to reach the underlying sink and
+// flushes it. It returns ErrSinkClosed once the sink is closed.
+func (b *BufferedSink) Flush() error {
+	b.closeMu.RLock()
+	if b.closed {
+		b.closeMu.RUnlock()
+		return ErrSinkClosed
+	}
+	reply := make(chan error)
+	b.flushes <- reply
+	b.closeMu.RUnlock()
+
+	if err := <-reply; err != nil {
+		return err
+	}
+	return b.getErr()
+}
+
+// Close drains the buffer and closes the underlying sink. Writes blocked
+// on a full buffer finish first; later ones return ErrSinkClosed, as does a
+// second Close.
+func (b *BufferedSink) Close() error {
+	b.closeMu.Lock()
+	if b.closed {
+		b.closeMu.Unlock()
+		return ErrSinkClosed
+	}
+	b.closed = true
+	close(b.queue)
+	b.closeMu.Unlock()
+
+	<-b.done
+	return b.getErr()
+}
+
+// AttachSink sends every result the workers produce to sink through a
+// buffer of size results. The sink is flushed and closed by Wait once the
+// workers finish, or by cancelling ctx, for a processor that is stopped
+// before its input runs out. Must be called before Start.
+func (dp *DataProcessor) AttachSink(ctx context.Context, sink Sink, size int) {
+	dp.sink = NewBufferedSink(sink, size)
+	context.AfterFunc(ctx, dp.CloseSink)
+}
+
+// CloseSink flushes and closes the attached sink, if any. Only the first
+// call closes it; later calls wait for that to finish.
+func (dp *DataProcessor) CloseSink() {
+	if dp.sink == nil {
+		return
+	}
+	dp.sinkOnce.Do(func() {
+		if err := dp.sink.Close(); err != nil {
+			log.Printf("Closing sink: %v\n", err)
+		}
+	})
+}
+
+// record hands a result to the attached sink, if any
+func (dp *DataProcessor) record(result ProcessedData) {
+	if dp.sink == nil {
+		return
+	}
+	if err := dp.sink.Write(result); err != nil {
+		log.Printf("Sink write for item %d failed: %v\n", result.ItemID, err)
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// rotatedFiles returns the contents of path's files, oldest first
+func rotatedFiles(t *testing.T, path string) []string {
+	t.Helper()
+	var files []string
+	for i := 1; ; i++ {
+		data, err := os.ReadFile(path + "." + strconv.Itoa(i))
+		if errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, string(data))
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(files, string(data))
+}
+
+func TestRotatingFileRotates(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   string
+		maxBytes int64
+		records  []string
+		want     []string // contents of each file, oldest first
+	}{
+		{"no limit", "", 0, []string{"aaaa\n", "bbbb\n", "cccc\n"}, []string{"aaaa\nbbbb\ncccc\n"}},
+		{"two records per file", "", 10, []string{"aaaa\n", "bbbb\n", "cccc\n"},
+			[]string{"aaaa\nbbbb\n", "cccc\n"}},
+		{"record never split", "", 12, []string{"aaaa\n", "bbbb\n", "cccc\n"},
+			[]string{"aaaa\nbbbb\n", "cccc\n"}},
+		{"header in every file", "h\n", 12, []string{"aaaa\n", "bbbb\n", "cccc\n"},
+			[]string{"h\naaaa\nbbbb\n", "h\ncccc\n"}},
+		{"oversized record gets its own file", "h\n", 8, []string{"aaaa\n", "bbbbbbbbbbbb\n", "cccc\n"},
+			[]string{"h\naaaa\n", "h\nbbbbbbbbbbbb\n", "h\ncccc\n"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "out")
+			var header []byte
+			if tt.header != "" {
+				header = []byte(tt.header)
+			}
+			r, err := OpenRotatingFile(path, header, RotateOptions{MaxBytes: tt.maxBytes})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, rec := range tt.records {
+				if err := r.WriteRecord([]byte(rec)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := r.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if got := rotatedFiles(t, path); !slices.Equal(got, tt.want) {
+				t.Errorf("files = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRotatingFileSyncs(t *testing.T) {
+	tests := []struct {
+		name string
+		opts RotateOptions
+	}{
+		{"every two records", RotateOptions{SyncEvery: 2}},
+		{"after an interval", RotateOptions{SyncInterval: 20 * time.Millisecond}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "out")
+			r, err := OpenRotatingFile(path, nil, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+
+			onDisk := func() string {
+				data, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return string(data)
+			}
+			if err := r.WriteRecord([]byte("a\n")); err != nil {
+				t.Fatal(err)
+			}
+			if got := onDisk(); got != "" {
+				t.Fatalf("%q on disk before a sync, want nothing", got)
+			}
+			if tt.opts.SyncEvery > 0 {
+				if err := r.WriteRecord([]byte("b\n")); err != nil {
+					t.Fatal(err)
+				}
+				if got := onDisk(); got != "a\nb\n" {
+					t.Errorf("%q on disk, want both records", got)
+				}
+				return
+			}
+			for deadline := time.Now().Add(time.Second); onDisk() != "a\n"; time.Sleep(time.Millisecond) {
+				if time.Now().After(deadline) {
+					t.Fatal("record not synced within a second")
+				}
+			}
+		})
+	}
+}
+
+func TestSinksRejectUseAfterClose(t *testing.T) {
+	open := map[string]func(path string) (Sink, error){
+		"jsonl": func(path string) (Sink, error) { return NewJSONLSink(path, RotateOptions{}) },
+		"csv":   func(path string) (Sink, error) { return NewCSVSink(path, RotateOptions{}) },
+		"buffered": func(path string) (Sink, error) {
+			s, err := NewJSONLSink(path, RotateOptions{})
+			if err != nil {
+				return nil, err
+			}
+			return NewBufferedSink(s, 4), nil
+		},
+	}
+	for name, open := range open {
+		t.Run(name, func(t *testing.T) {
+			s, err := open(filepath.Join(t.TempDir(), "out"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Write(ProcessedData{ItemID: 1}); !errors.Is(err, ErrSinkClosed) {
+				t.Errorf("Write = %v, want %v", err, ErrSinkClosed)
+			}
+			if err := s.Flush(); !errors.Is(err, ErrSinkClosed) {
+				t.Errorf("Flush = %v, want %v", err, ErrSinkClosed)
+			}
+			if err := s.Close(); !errors.Is(err, ErrSinkClosed) {
+				t.Errorf("second Close = %v, want %v", err, ErrSinkClosed)
+			}
+		})
+	}
+}
+
+func TestCSVSinkConcurrentWrites(t *testing.T) {
+	const writers, each = 8, 50
+	path := filepath.Join(t.TempDir(), "out.csv")
+	s, err := NewCSVSink(path, RotateOptions{MaxBytes: 4096})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < each; i++ {
+				id := w*each + i + 1
+				if err := s.Write(ProcessedData{ItemID: id, Original: id, Result: id * id, WorkerID: w + 1}); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every file starts with the header, and every row is whole
+	seen := make(map[int]bool)
+	files := rotatedFiles(t, path)
+	if len(files) < 2 {
+		t.Errorf("%d files, want the output rotated", len(files))
+	}
+	for i, data := range files {
+		rows, err := csv.NewReader(strings.NewReader(data)).ReadAll()
+		if err != nil {
+			t.Fatalf("file %d: %v", i, err)
+		}
+		if len(rows) == 0 || !slices.Equal(rows[0], csvHeader) {
+			t.Fatalf("file %d does not start with the header", i)
+		}
+		for _, row := range rows[1:] {
+			id, _ := strconv.Atoi(row[0])
+			if result, _ := strconv.Atoi(row[2]); seen[id] || result != id*id {
+				t.Errorf("file %d: bad or repeated row %v", i, row)
+			}
+			seen[id] = true
+		}
+	}
+	if len(seen) != writers*each {
+		t.Errorf("%d rows written, want %d", len(seen), writers*each)
+	}
+}
+
+func TestBufferedSinkFlushWritesQueued(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.jsonl")
+	inner, err := NewJSONLSink(path, RotateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewBufferedSink(inner, 2)
+	defer s.Close()
+
+	const n = 20
+	for i := 1; i <= n; i++ {
+		if err := s.Write(ProcessedData{ItemID: i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var ids []int
+	for sc := bufio.NewScanner(f); sc.Scan(); {
+		var r ProcessedData
+		if err := json.Unmarshal(sc.Bytes(), &r); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, r.ItemID)
+	}
+	for i, id := range ids {
+		if id != i+1 {
+			t.Fatalf("results out of order: %v", ids)
+		}
+	}
+	if len(ids) != n {
+		t.Errorf("%d results on disk after Flush, want %d", len(ids), n)
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+)
+
+// SpeculationPolicy controls when a slow item gets a duplicate attempt
+type SpeculationPolicy struct {
+	// Threshold re-runs any item that has been in flight longer than this.
+	// Zero disables the fixed threshold.
+	Threshold time.Duration
+	// Percentile re-runs any item slower than this percentile (0-1) of
+	// recent latencies. Zero disables the percentile check.
+	Percentile float64
+	// Window is how many recent latencies are kept for the percentile.
+	Window int
+	// MinSamples is how many latencies must be seen before the percentile
+	// check kicks in.
+	MinSamples int
+	// Interval is how often in-flight items are checked.
+	Interval time.Duration
+}
+
+// inflightItem tracks every attempt at one item until the first one finishes
+type inflightItem struct {
+	item     DataItem
+	started  time.Time
+	attempts int
+	ctx      context.Context
+	cancel   context.CancelFunc
+}
+
+// EnableSpeculation turns on stuck-item detection. Must be called before Start.
+func (dp *DataProcessor) EnableSpeculation(policy SpeculationPolicy) {
+	if policy.Window <= 0 {
+		policy.Window = 100
+	}
+	if policy.MinSamples <= 0 {
+		policy.MinSamples = 5
+	}
+	if policy.Interval <= 0 {
+		policy.Interval = 50 * time.Millisecond
+	}
+
+	dp.speculation = &policy
+	dp.retries = make(chan DataItem, dp.numWorkers)
+	dp.inflight = make(map[int]*inflightItem)
+	dp.completed = make(map[int]bool)
+	dp.drained = make(chan struct{})
+	dp.done = make(chan struct{})
+}
+
+// GetSpeculationStats returns how many duplicate attempts were launched and
+// how many of them finished before the original
+func (dp *DataProcessor) GetSpeculationStats() (launched int, won int) {
+	dp.mu.Lock()
+	defer dp.mu.Unlock()
+	return dp.speculated, dp.speculativeWins
+}
+
+// begin registers an attempt at item and returns the context it should run
+// under. It returns false if the item has already been completed by another
+// attempt.
+func (dp *DataProcessor) begin(item DataItem) (context.Context, bool) {
+	if dp.speculation == nil {
+		return context.Background(), true
+	}
+
+	dp.mu.Lock()
+	defer dp.mu.Unlock()
+
+	if f, ok := dp.inflight[item.ID]; ok {
+		return f.ctx, true
+	}
+	if dp.completed[item.ID] {
+		return nil, false
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	dp.inflight[item.ID] = &inflightItem{
+		item:     item,
+		started:  time.Now(),
+		attempts: 1,
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+	return ctx, true
+}
+
+// finish reports whether the calling attempt is the first to complete item.
+// The winner cancels every other attempt still running.
+func (dp *DataProcessor) finish(itemID int, speculative bool) bool {
+	if dp.speculation == nil {
+		return true
+	}
+
+	dp.mu.Lock()
+	defer dp.mu.Unlock()
+
+	f, ok := dp.inflight[itemID]
+	if !ok {
+		return false
+	}
+	delete(dp.inflight, itemID)
+	dp.completed[itemID] = true
+	f.cancel()
+
+	dp.latencies = append(dp.latencies, time.Since(f.started))
+	if len(dp.latencies) > dp.speculation.Window {
+		dp.latencies = dp.latencies[len(dp.latencies)-dp.speculation.Window:]
+	}
+	if speculative {
+		dp.speculativeWins++
+	}
+	dp.checkDrained()
+	return true
+}
+
+// stopSpeculation stops the speculation loop. It is safe to call more than
+// once, so Wait may be too.
+func (dp *DataProcessor) stopSpeculation() {
+	if dp.done == nil {
+		return
+	}
+	dp.doneOnce.Do(func() { close(dp.done) })
+}
+
+// inputClosed records that no new items will arrive
+func (dp *DataProcessor) inputClosed() {
+	dp.mu.Lock()
+	defer dp.mu.Unlock()
+	dp.inputDone = true
+	dp.checkDrained()
+}
+
+// checkDrained releases workers once the input is closed and nothing is
+// left in flight. Caller must hold dp.mu.
+func (dp *DataProcessor) checkDrained() {
+	if dp.inputDone && len(dp.inflight) == 0 {
+		dp.drainOnce.Do(func() { close(dp.drained) })
+	}
+}
+
+// cutoff returns the in-flight duration after which an item is considered
+// stuck, or zero if no limit applies yet. Caller must hold dp.mu.
+func (dp *DataProcessor) cutoff() time.Duration {
+	limit := dp.speculation.Threshold
+
+	if dp.speculation.Percentile > 0 && len(dp.latencies) >= dp.speculation.MinSamples {
+		sorted := make([]time.Duration, len(dp.latencies))
+		copy(sorted, dp.latencies)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+		idx := int(dp.speculation.Percentile * float64(len(sorted)-1))
+		if p := sorted[idx]; limit == 0 || p < limit {
+			limit = p
+		}
+	}
+	return limit
+}
+
+// speculate periodically looks for stuck items and hands a duplicate attempt
+// to an idle worker
+func (dp *DataProcessor) speculate() {
+	ticker := time.NewTicker(dp.speculation.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-dp.done:
+			return
+		}
+
+		dp.mu.Lock()
+		limit := dp.cutoff()
+		if limit == 0 {
+			dp.mu.Unlock()
+			continue
+		}
+
+		var stuck []*inflightItem
+		free := dp.idle - len(dp.retries)
+		for _, f := range dp.inflight {
+			if free <= 0 {
+				break
+			}
+			if f.attempts > 1 || time.Since(f.started) < limit {
+				continue
+			}
+			free--
+			stuck = append(stuck, f)
+		}
+		dp.mu.Unlock()
+
+		// An attempt only counts once a worker can take it; otherwise the
+		// item stays eligible for the next tick
+		for _, f := range stuck {
+			select {
+			case dp.retries <- f.item:
+			default:
+				// Every worker picked up new work in the meantime; the
+				// original attempt is still running.
+				continue
+			}
+			dp.mu.Lock()
+			f.attempts++
+			dp.speculated++
+			log.Printf("Item %d in flight for %v, launched speculative attempt\n",
+				f.item.ID, time.Since(f.started).Round(time.Millisecond))
+			dp.mu.Unlock()
+		}
+	}
+}
+
+// setIdle records whether a worker is waiting for work
+func (dp *DataProcessor) setIdle(idle bool) {
+	if dp.speculation == nil {
+		return
+	}
+	dp.mu.Lock()
+	if idle {
+		dp.idle++
+	} else {
+		dp.idle--
+	}
+	dp.mu.Unlock()
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSpeculationRerunsSlowItem(t *testing.T) {
+	const slowID = 3
+	var slowCalls atomic.Int32
+	duplicate := make(chan struct{})
+	dp := NewDataProcessor(2)
+	dp.Transform = func(v int) int {
+		// The first attempt at the slow item hangs until the duplicate gets
+		// this far. Waiting on it rather than sleeping keeps the simulated
+		// delays, and workers stuck at random, from deciding the outcome.
+		if v == slowID {
+			switch slowCalls.Add(1) {
+			case 1:
+				select {
+				case <-duplicate:
+				case <-time.After(10 * time.Second):
+				}
+			case 2:
+				close(duplicate)
+			}
+		}
+		return v * 10
+	}
+	dp.EnableSpeculation(SpeculationPolicy{Threshold: 800 * time.Millisecond, Interval: 20 * time.Millisecond})
+	dp.Start()
+
+	go func() {
+		for id := 1; id <= 4; id++ {
+			dp.input <- DataItem{ID: id, Value: id}
+		}
+		close(dp.input)
+	}()
+
+	results := make(map[int]int)
+	collected := make(chan struct{})
+	go func() {
+		defer close(collected)
+		for r := range dp.output {
+			results[r.ItemID]++
+			if r.Result != r.Original*10 {
+				t.Errorf("item %d: result %d, want %d", r.ItemID, r.Result, r.Original*10)
+			}
+		}
+	}()
+
+	dp.Wait()
+	close(dp.output)
+	<-collected
+
+	for id := 1; id <= 4; id++ {
+		if results[id] != 1 {
+			t.Errorf("item %d: %d results, want 1", id, results[id])
+		}
+	}
+	if got := slowCalls.Load(); got != 2 {
+		t.Errorf("slow item attempted %d times, want 2", got)
+	}
+	if launched, _ := dp.GetSpeculationStats(); launched < 1 {
+		t.Errorf("%d speculative attempts launched, want at least 1", launched)
+	}
+	if processed, _ := dp.GetStats(); processed != 4 {
+		t.Errorf("processed %d items, want 4", processed)
+	}
+}
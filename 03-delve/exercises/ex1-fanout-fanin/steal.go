@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// partition is a queue of pending items owned by a group of workers.
+// Owners take from the front, thieves take from the back.
+type partition struct {
+	mu    sync.Mutex
+	items []DataItem
+}
+
+// popFront removes the next item for an owning worker
+func (p *partition) popFront() (DataItem, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.items) == 0 {
+		return DataItem{}, false
+	}
+	item := p.items[0]
+	p.items = p.items[1:]
+	return item, true
+}
+
+// popBack removes the item furthest from the owners' position
+func (p *partition) popBack() (DataItem, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.items) == 0 {
+		return DataItem{}, false
+	}
+	item := p.items[len(p.items)-1]
+	p.items = p.items[:len(p.items)-1]
+	return item, true
+}
+
+// pending returns how many items are still queued
+func (p *partition) pending() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.items)
+}
+
+// StealingScheduler processes items split into partitions. Each partition has
+// its own workers; a worker whose partition runs dry steals pending items from
+// the busiest partition instead of sitting idle.
+type StealingScheduler struct {
+	numPartitions int
+	workersPer    int
+	partitions    []*partition
+	wg            sync.WaitGroup
+	mu            sync.Mutex
+	stolen        int
+	errors_cnt    int
+}
+
+// NewStealingScheduler creates a scheduler with workersPer workers for each
+// of numPartitions partitions. Both must be at least 1.
+func NewStealingScheduler(numPartitions, workersPer int) (*StealingScheduler, error) {
+	if numPartitions <= 0 || workersPer <= 0 {
+		return nil, fmt.Errorf("stealing scheduler: need at least one partition and worker per partition, got %d and %d",
+			numPartitions, workersPer)
+	}
+	return &StealingScheduler{
+		numPartitions: numPartitions,
+		workersPer:    workersPer,
+	}, nil
+}
+
+// Run splits items into partitions and processes them. The returned channel
+// is closed once every item has been handled.
+func (s *StealingScheduler) Run(items []DataItem) <-chan ProcessedData {
+	s.partitions = make([]*partition, s.numPartitions)
+	chunkSize := len(items) / s.numPartitions
+	for i := range s.partitions {
+		start := i * chunkSize
+		end := start + chunkSize
+		if i == s.numPartitions-1 {
+			end = len(items)
+		}
+		s.partitions[i] = &partition{items: append([]DataItem(nil), items[start:end]...)}
+	}
+
+	output := make(chan ProcessedData, len(items))
+	for p := 0; p < s.numPartitions; p++ {
+		for w := 0; w < s.workersPer; w++ {
+			s.wg.Add(1)
+			go s.worker(p*s.workersPer+w+1, p, output)
+		}
+	}
+
+	go func() {
+		s.wg.Wait()
+		close(output)
+	}()
+
+	return output
+}
+
+// Stats returns how many items were stolen across partitions and how many
+// items failed
+func (s *StealingScheduler) Stats() (stolen int, errors int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stolen, s.errors_cnt
+}
+
+// worker drains its own partition, then steals from the others
+func (s *StealingScheduler) worker(id, home int, output chan<- ProcessedData) {
+	defer s.wg.Done()
+
+	for {
+		item, ok := s.partitions[home].popFront()
+		if !ok {
+			item, ok = s.steal(id, home)
+			if !ok {
+				log.Printf("Worker %d (partition %d) shutting down\n", id, home)
+				return
+			}
+		}
+
+		if item.Value < 0 {
+			err := fmt.Errorf("worker %d: negative value %d for item %d",
+				id, item.Value, item.ID)
+			log.Println(err)
+
+			s.mu.Lock()
+			s.errors_cnt++
+			s.mu.Unlock()
+			continue
+		}
+
+		result, _ := processItem(context.Background(), id, item, nil)
+		output <- result
+	}
+}
+
+// steal takes an item from the partition with the most pending work.
+// Returns false once every partition is empty.
+func (s *StealingScheduler) steal(id, home int) (DataItem, bool) {
+	for {
+		victim, most := -1, 0
+		for i, p := range s.partitions {
+			if i == home {
+				continue
+			}
+			if n := p.pending(); n > most {
+				victim, most = i, n
+			}
+		}
+		if victim < 0 {
+			return DataItem{}, false
+		}
+
+		// The victim may have drained between pending and popBack;
+		// look again if so.
+		if item, ok := s.partitions[victim].popBack(); ok {
+			s.mu.Lock()
+			s.stolen++
+			s.mu.Unlock()
+			log.Printf("Worker %d (partition %d) stole item %d from partition %d\n",
+				id, home, item.ID, victim)
+			return item, true
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import "testing"
+
+func TestNewStealingSchedulerValidates(t *testing.T) {
+	tests := []struct {
+		name                      string
+		numPartitions, workersPer int
+		wantErr                   bool
+	}{
+		{"valid", 2, 1, false},
+		{"no partitions", 0, 2, true},
+		{"negative partitions", -1, 2, true},
+		{"no workers", 2, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewStealingScheduler(tt.numPartitions, tt.workersPer)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil && s != nil {
+				t.Error("got a scheduler along with the error")
+			}
+		})
+	}
+}
+
+func TestStealingDrainsSkewedPartition(t *testing.T) {
+	// 15 items over 8 partitions: the first 7 get one item each and the
+	// last gets 8. Every item takes at least 100ms, so the last partition's
+	// own worker cannot finish before the others run dry and steal from it.
+	const numPartitions = 8
+	items := make([]DataItem, 15)
+	for i := range items {
+		items[i] = DataItem{ID: i + 1, Value: i + 1}
+	}
+	s, err := NewStealingScheduler(numPartitions, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seen := make(map[int]int)
+	helped := 0 // items of the skewed partition run by other workers
+	for r := range s.Run(items) {
+		seen[r.ItemID]++
+		if r.ItemID >= numPartitions && r.WorkerID != numPartitions {
+			helped++
+		}
+	}
+
+	for _, item := range items {
+		if seen[item.ID] != 1 {
+			t.Errorf("item %d processed %d times, want 1", item.ID, seen[item.ID])
+		}
+	}
+	stolen, failed := s.Stats()
+	if stolen == 0 || stolen != helped {
+		t.Errorf("stolen = %d, other workers ran %d of the skewed partition's items; want them equal and above 0", stolen, helped)
+	}
+	if failed != 0 {
+		t.Errorf("errors = %d, want 0", failed)
+	}
+}
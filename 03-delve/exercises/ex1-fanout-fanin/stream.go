@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"log"
+	"sync"
+)
+
+// streamResult is one value handed from a streaming worker to the caller
+type streamResult struct {
+	result ProcessedData
+	err    error
+}
+
+// Stream processes items with the processor's workers and yields each result
+// as soon as it is ready. Negative values are yielded as errors. Items are
+// pulled from the input lazily, so neither the input nor the results need to
+// fit in memory.
+//
+// Breaking out of the loop cancels the workers, and Stream does not return
+// until every goroutine it started has exited. If ctx is cancelled, the last
+// value yielded carries ctx.Err().
+func (dp *DataProcessor) Stream(ctx context.Context, items iter.Seq[DataItem]) iter.Seq2[ProcessedData, error] {
+	return func(yield func(ProcessedData, error) bool) {
+		runCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		input := make(chan DataItem)
+		results := make(chan streamResult)
+
+		var feeder sync.WaitGroup
+		feeder.Add(1)
+		go func() {
+			defer feeder.Done()
+			defer close(input)
+			for item := range items {
+				select {
+				case input <- item:
+				case <-runCtx.Done():
+					return
+				}
+			}
+		}()
+
+		var workers sync.WaitGroup
+		for i := 0; i < dp.numWorkers; i++ {
+			workers.Add(1)
+			go func(id int) {
+				defer workers.Done()
+				dp.streamWorker(runCtx, id, input, results)
+			}(i + 1)
+		}
+
+		go func() {
+			workers.Wait()
+			close(results)
+		}()
+
+		// Release everything before returning, however the loop ends
+		defer func() {
+			cancel()
+			for range results {
+			}
+			feeder.Wait()
+		}()
+
+		for r := range results {
+			if !yield(r.result, r.err) {
+				return
+			}
+		}
+
+		if err := ctx.Err(); err != nil {
+			yield(ProcessedData{}, err)
+		}
+	}
+}
+
+// streamWorker processes items for Stream until input closes or ctx is
+// cancelled
+func (dp *DataProcessor) streamWorker(ctx context.Context, id int, input <-chan DataItem, results chan<- streamResult) {
+	for item := range input {
+		r, ok := dp.process(ctx, id, item)
+		if !ok {
+			log.Printf("Worker %d cancelled on item %d\n", id, item.ID)
+			return
+		}
+
+		select {
+		case results <- r:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// process handles one item for worker id: a negative value is an error,
+// anything else goes through Transform. Either way it is counted in the
+// processor's stats. It returns false if ctx is cancelled first.
+func (dp *DataProcessor) process(ctx context.Context, id int, item DataItem) (streamResult, bool) {
+	if item.Value < 0 {
+		dp.mu.Lock()
+		dp.errors_cnt++
+		dp.mu.Unlock()
+
+		return streamResult{err: fmt.Errorf("worker %d: negative value %d for item %d",
+			id, item.Value, item.ID)}, true
+	}
+
+	result, ok := processItem(ctx, id, item, dp.Transform)
+	if !ok {
+		return streamResult{}, false
+	}
+
+	dp.mu.Lock()
+	dp.processed++
+	dp.mu.Unlock()
+	return streamResult{result: result}, true
+}
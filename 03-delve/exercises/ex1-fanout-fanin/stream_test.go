@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"iter"
+	"sync/atomic"
+	"testing"
+)
+
+// endlessItems yields items with increasing values for ever, recording when
+// the iterator has returned
+func endlessItems(finished *atomic.Bool) iter.Seq[DataItem] {
+	return func(yield func(DataItem) bool) {
+		defer finished.Store(true)
+		for id := 1; ; id++ {
+			if !yield(DataItem{ID: id, Value: id}) {
+				return
+			}
+		}
+	}
+}
+
+func TestStreamYieldsResultsAndErrors(t *testing.T) {
+	dp := NewDataProcessor(2)
+	items := []DataItem{{ID: 1, Value: 2}, {ID: 2, Value: -3}, {ID: 3, Value: 4}}
+	seq := func(yield func(DataItem) bool) {
+		for _, item := range items {
+			if !yield(item) {
+				return
+			}
+		}
+	}
+
+	results := make(map[int]int)
+	var errs int
+	for r, err := range dp.Stream(context.Background(), seq) {
+		if err != nil {
+			errs++
+			continue
+		}
+		results[r.ItemID] = r.Result
+	}
+	if errs != 1 || results[1] != 4 || results[3] != 16 || len(results) != 2 {
+		t.Errorf("results %v and %d errors, want items 1 and 3 squared and 1 error", results, errs)
+	}
+	if processed, failed := dp.GetStats(); processed != 2 || failed != 1 {
+		t.Errorf("stats = %d processed, %d errors; want 2 and 1", processed, failed)
+	}
+}
+
+func TestStreamBreakStopsEverything(t *testing.T) {
+	var finished atomic.Bool
+	dp := NewDataProcessor(3)
+
+	got := 0
+	for _, err := range dp.Stream(context.Background(), endlessItems(&finished)) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got++; got == 2 {
+			break
+		}
+	}
+
+	// Stream only returns once its goroutines have, including the one
+	// pulling from the input
+	if !finished.Load() {
+		t.Error("input still being pulled after the loop ended")
+	}
+}
+
+func TestStreamCancelYieldsContextError(t *testing.T) {
+	var finished atomic.Bool
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dp := NewDataProcessor(2)
+
+	var last error
+	results := 0
+	for _, err := range dp.Stream(ctx, endlessItems(&finished)) {
+		if err != nil {
+			last = err
+			continue
+		}
+		results++
+		cancel()
+	}
+
+	if results == 0 {
+		t.Fatal("no results before cancelling")
+	}
+	if !errors.Is(last, context.Canceled) {
+		t.Errorf("last value yielded %v, want %v", last, context.Canceled)
+	}
+	if !finished.Load() {
+		t.Error("input still being pulled after Stream returned")
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/derekparker/gophercon-2025/03-delve/demo/supervisor"
+)
+
+// Supervise runs the processor's workers under a supervisor, so a panicking
+// worker is restarted instead of crashing the process or silently
+// disappearing. Must be called before Start.
+func (dp *DataProcessor) Supervise(strategy supervisor.Strategy, maxRestarts int, window time.Duration) {
+	dp.supervisor = supervisor.New(strategy, maxRestarts, window, dp.handlePanic)
+}
+
+// startSupervised launches the workers as children of dp.supervisor
+func (dp *DataProcessor) startSupervised() {
+	for i := 0; i < dp.numWorkers; i++ {
+		id := i + 1
+		dp.supervisor.Add(fmt.Sprintf("worker %d", id), func(ctx context.Context, track func(any)) {
+			dp.work(ctx, id, track)
+		})
+	}
+
+	dp.wg.Add(1)
+	go func() {
+		defer dp.wg.Done()
+		if err := dp.supervisor.Run(context.Background()); err != nil {
+			log.Printf("Supervisor stopped: %v\n", err)
+		}
+	}()
+}
+
+// handlePanic counts a worker panic and sends it down the error path if
+// there is room
+func (dp *DataProcessor) handlePanic(p *supervisor.PanicError) {
+	log.Printf("%v\n%s", p, p.Stack)
+
+	if item, ok := p.Item.(DataItem); ok {
+		dp.abandon(item.ID)
+	}
+
+	dp.mu.Lock()
+	dp.errors_cnt++
+	dp.mu.Unlock()
+
+	// This runs on the supervisor's goroutine, so it must not wait for a
+	// reader: if the errors channel is full the panic is only counted
+	select {
+	case dp.errors <- p:
+	default:
+		log.Printf("Errors channel full, dropped panic of %s\n", p.Child)
+	}
+}
+
+// abandon drops an item from in-flight tracking without recording a result
+func (dp *DataProcessor) abandon(itemID int) {
+	if dp.speculation == nil {
+		return
+	}
+
+	dp.mu.Lock()
+	defer dp.mu.Unlock()
+
+	if f, ok := dp.inflight[itemID]; ok {
+		delete(dp.inflight, itemID)
+		dp.completed[itemID] = true
+		f.cancel()
+		dp.checkDrained()
+	}
+}
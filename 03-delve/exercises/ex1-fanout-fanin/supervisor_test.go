@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/derekparker/gophercon-2025/03-delve/demo/supervisor"
+)
+
+func TestHandlePanicNeverBlocks(t *testing.T) {
+	tests := []struct {
+		name   string
+		panics int
+	}{
+		{"one panic", 1},
+		{"more panics than the errors buffer", 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dp := NewDataProcessor(1)
+
+			// Nothing reads dp.errors, as when the caller only drains output
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for i := 0; i < tt.panics; i++ {
+					dp.handlePanic(&supervisor.PanicError{Child: "worker 1", Item: DataItem{ID: i}, Value: "boom"})
+				}
+			}()
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("handlePanic blocked on a full errors channel")
+			}
+
+			if dp.errors_cnt != tt.panics {
+				t.Errorf("errors_cnt = %d, want %d", dp.errors_cnt, tt.panics)
+			}
+			if got, want := len(dp.errors), min(tt.panics, cap(dp.errors)); got != want {
+				t.Errorf("%d panics on the errors channel, want %d", got, want)
+			}
+		})
+	}
+}